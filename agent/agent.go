@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -33,15 +34,22 @@ func (pt *processedTrace) weight() float64 {
 // Agent struct holds all the sub-routines structs and make the data flow between them
 type Agent struct {
 	Receiver     *HTTPReceiver
+	Assembler    *TraceAssembler
+	Ignorer      *TraceIgnorer
+	Rewriter     *TraceRewriter
+	Obfuscator   *Obfuscator
 	Concentrator *Concentrator
 	Sampler      *Sampler
 	Writer       *Writer
 
 	// config
-	conf *config.AgentConfig
+	conf    *config.AgentConfig
+	dynConf *config.DynamicConfig
 
 	// Used to synchronize on a clean exit
 	exit chan struct{}
+	// processing tracks the traces being added to the concentrator and the sampler
+	processing sync.WaitGroup
 
 	die func(format string, args ...interface{})
 }
@@ -49,26 +57,38 @@ type Agent struct {
 // NewAgent returns a new Agent object, ready to be started
 func NewAgent(conf *config.AgentConfig) *Agent {
 	exit := make(chan struct{})
+	dynConf := config.NewDynamicConfig()
 
-	r := NewHTTPReceiver(conf)
+	r := NewHTTPReceiver(conf, dynConf)
 	c := NewConcentrator(
 		conf.ExtraAggregators,
 		conf.BucketInterval.Nanoseconds(),
 	)
-	s := NewSampler(conf)
+	c.measuredOnly = !conf.InternalSpanStats
+	c.maxErrorTypes = conf.MaxErrorTypes
+	c.apdex = newApdexThresholds(conf)
+	c.maxGrainsPerService = conf.MaxGrainsPerService
+	s := NewSampler(conf, dynConf)
 
 	w := NewWriter(conf)
 	w.inServices = r.services
 
-	return &Agent{
+	a := &Agent{
 		Receiver:     r,
+		Ignorer:      NewTraceIgnorer(conf),
+		Rewriter:     NewTraceRewriter(conf),
+		Obfuscator:   NewObfuscator(conf),
 		Concentrator: c,
 		Sampler:      s,
 		Writer:       w,
 		conf:         conf,
+		dynConf:      dynConf,
 		exit:         exit,
 		die:          die,
 	}
+	a.Assembler = NewTraceAssembler(conf, a.Process)
+
+	return a
 }
 
 // Run starts routers routines and individual pieces then stop them when the exit order is received
@@ -82,6 +102,14 @@ func (a *Agent) Run() {
 	watchdogTicker := time.NewTicker(a.conf.WatchdogInterval)
 	defer watchdogTicker.Stop()
 
+	// a nil channel blocks forever, which is what we want if the assembler is disabled
+	var assemblerC <-chan time.Time
+	if a.Assembler.Enabled() {
+		assemblerTicker := time.NewTicker(a.Assembler.FlushInterval())
+		defer assemblerTicker.Stop()
+		assemblerC = assemblerTicker.C
+	}
+
 	// update the data served by expvar so that we don't expose a 0 sample rate
 	updatePreSampler(*a.Receiver.preSampler.Stats())
 
@@ -91,9 +119,13 @@ func (a *Agent) Run() {
 
 	for {
 		select {
-		case t := <-a.Receiver.traces:
-			a.Process(t)
+		case rt := <-a.Receiver.traces:
+			a.Assembler.Add(rt.trace, rt.tags, time.Now())
+		case now := <-assemblerC:
+			a.Assembler.Flush(now)
 		case <-flushTicker.C:
+			a.Ignorer.logStats()
+			a.Rewriter.logStats()
 			p := model.AgentPayload{
 				HostName: a.conf.HostName,
 				Env:      a.conf.DefaultEnv,
@@ -118,17 +150,15 @@ func (a *Agent) Run() {
 			a.watchdog()
 		case <-a.exit:
 			log.Info("exiting")
-			close(a.Receiver.exit)
-			a.Writer.Stop()
-			a.Sampler.Stop()
+			a.shutdown()
 			return
 		}
 	}
 }
 
 // Process is the default work unit that receives a trace, transforms it and
-// passes it downstream
-func (a *Agent) Process(t model.Trace) {
+// passes it downstream. tags are the ones of the client library which sent it.
+func (a *Agent) Process(t model.Trace, tags receiverTags) {
 	if len(t) == 0 {
 		// XXX Should never happen since we reject empty traces during
 		// normalization.
@@ -137,17 +167,27 @@ func (a *Agent) Process(t model.Trace) {
 	}
 
 	root := t.GetRoot()
+	if a.Ignorer.Ignore(root) {
+		log.Debugf("skipping trace matching an ignore rule, root:%v", *root)
+		return
+	}
+
 	if root.End() < model.Now()-2*a.conf.BucketInterval.Nanoseconds() {
 		log.Errorf("skipping trace with root too far in past, root:%v", *root)
-		atomic.AddInt64(&a.Receiver.stats.TracesDropped, 1)
-		atomic.AddInt64(&a.Receiver.stats.SpansDropped, int64(len(t)))
+		ts := a.Receiver.stats.get(tags)
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(len(t)))
 		return
 	}
 
+	a.Rewriter.Rewrite(t)
+	a.Obfuscator.Obfuscate(t)
+
 	rate := sampler.GetTraceAppliedSampleRate(root)
 	rate *= a.Receiver.preSampler.Rate()
 	sampler.SetTraceAppliedSampleRate(root, rate)
 
+	t.CorrectClockSkew()
 	t.ComputeTopLevel()
 
 	sublayers := model.ComputeSublayers(&t)
@@ -171,17 +211,65 @@ func (a *Agent) Process(t model.Trace) {
 	// as they access the Metrics map, which is not thread safe.
 	t.ComputeWeight(*root)
 	t.ComputeTopLevel()
+	t.ComputeMeasured()
+	t.ComputeExtraMeasures(a.conf.ExtraMeasures)
+	a.processing.Add(2)
 	go func() {
 		defer watchdog.LogOnPanic()
+		defer a.processing.Done()
 		a.Concentrator.Add(pt)
-
 	}()
 	go func() {
 		defer watchdog.LogOnPanic()
+		defer a.processing.Done()
 		a.Sampler.Add(pt)
 	}()
 }
 
+// shutdown stops the agent without losing the data it holds: it stops
+// receiving traces, processes the ones already received, and flushes all the
+// stats and traces, giving up after conf.ShutdownTimeout.
+func (a *Agent) shutdown() {
+	deadline := time.Now().Add(a.conf.ShutdownTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if err := a.Receiver.Stop(ctx); err != nil {
+		log.Errorf("stopped receiving traces before all requests completed: %v", err)
+	}
+
+	drained := 0
+	for done := false; !done; {
+		select {
+		case rt := <-a.Receiver.traces:
+			a.Assembler.Add(rt.trace, rt.tags, time.Now())
+			drained++
+		default:
+			done = true
+		}
+	}
+	a.Assembler.FlushAll()
+	a.processing.Wait()
+	log.Infof("processed %d traces queued before exiting", drained)
+
+	p := model.AgentPayload{
+		HostName: a.conf.HostName,
+		Env:      a.conf.DefaultEnv,
+		Stats:    a.Concentrator.FlushAll(),
+		Traces:   a.Sampler.Flush(),
+	}
+	a.Sampler.Stop()
+
+	select {
+	case a.Writer.inPayloads <- p:
+	case <-ctx.Done():
+		log.Errorf("dropping the last payload, the writer is still busy after %v", a.conf.ShutdownTimeout)
+	}
+	if !a.Writer.StopTimeout(time.Until(deadline)) {
+		log.Errorf("gave up flushing the last payloads after %v", a.conf.ShutdownTimeout)
+	}
+}
+
 func (a *Agent) watchdog() {
 	var wi watchdog.Info
 	wi.CPU = watchdog.CPU()
@@ -9,9 +9,11 @@ import (
 	"time"
 
 	log "github.com/cihub/seelog"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
 )
 
 func TestWatchdog(t *testing.T) {
@@ -73,6 +75,29 @@ func TestWatchdog(t *testing.T) {
 	buf[len(buf)-1] = 2
 }
 
+func TestAgentProcessTooOld(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "key"
+	a := NewAgent(conf)
+
+	start := model.Now() - 3*conf.BucketInterval.Nanoseconds()
+	trace := model.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /", Start: start, Duration: 1000},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "web", Name: "sql.query", Resource: "SELECT 1", Start: start, Duration: 100},
+	}
+	tags := receiverTags{Lang: "python", LangVersion: "2.7.14", TracerVersion: "0.10.0"}
+	a.Process(trace, tags)
+	a.processing.Wait()
+
+	assert.Len(a.Concentrator.FlushAll(), 0)
+	// accounted to the client library which sent it
+	stats := a.Receiver.stats.swap()
+	assert.Equal(receiverStats{TracesDropped: 1, SpansDropped: 2}, stats[tags])
+	assert.Len(stats, 1)
+}
+
 func BenchmarkAgentTraceProcessing(b *testing.B) {
 	// Disable debug logs in these tests
 	log.UseLogger(log.Disabled)
@@ -82,7 +107,7 @@ func BenchmarkAgentTraceProcessing(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		agent.Process(fixtures.RandomTrace(10, 8))
+		agent.Process(fixtures.RandomTrace(10, 8), receiverTags{})
 	}
 }
 
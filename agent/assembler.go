@@ -0,0 +1,199 @@
+package main
+
+import (
+	"container/list"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+// TraceAssembler buffers the spans of traces which arrive in several
+// payloads, until their root span arrives or a timeout elapses, so that
+// they are processed as a whole. Traces which arrive with their root are
+// passed through right away.
+//
+// It is not thread-safe: it is meant to be used from the agent main loop only.
+type TraceAssembler struct {
+	timeout  time.Duration // if 0, traces are passed through as they come
+	maxSpans int           // max number of spans buffered at once
+	out      func(model.Trace, receiverTags)
+
+	pending map[model.TraceKey]*list.Element // pendingTrace by trace ID
+	order   *list.List                       // pendingTrace, oldest first
+	spans   int                              // number of spans buffered
+
+	stats     assemblerStats
+	lastStats time.Time
+}
+
+type pendingTrace struct {
+	traceID  model.TraceKey
+	trace    model.Trace
+	tags     receiverTags // of the client library which sent the first fragment
+	spanIDs  map[uint64]struct{}
+	deadline time.Time
+}
+
+// assemblerStats contains stats about the trace assembler
+type assemblerStats struct {
+	// TracesAssembled is the number of traces which arrived in several payloads and were completed by their root
+	TracesAssembled int64
+	// TracesIncomplete is the number of traces flushed without their root, after the timeout
+	TracesIncomplete int64
+	// TracesEvicted is the number of traces flushed without their root, because too many spans were buffered
+	TracesEvicted int64
+	// SpansBuffered is the number of spans waiting for the rest of their trace
+	SpansBuffered int64
+}
+
+// NewTraceAssembler creates a new TraceAssembler, passing complete traces to out,
+// along with the receiver tags of the client library which sent them
+func NewTraceAssembler(conf *config.AgentConfig, out func(model.Trace, receiverTags)) *TraceAssembler {
+	timeout := conf.AssemblerTimeout
+	// traces whose root ended more than 2 buckets ago are dropped, don't let them get that old
+	if timeout > conf.BucketInterval {
+		log.Warnf("trace assembler timeout %v is too long, using %v instead", timeout, conf.BucketInterval)
+		timeout = conf.BucketInterval
+	}
+
+	return &TraceAssembler{
+		timeout:   timeout,
+		maxSpans:  conf.AssemblerMaxSpans,
+		out:       out,
+		pending:   make(map[model.TraceKey]*list.Element),
+		order:     list.New(),
+		lastStats: time.Now(),
+	}
+}
+
+// Enabled tells if traces are being assembled at all
+func (ta *TraceAssembler) Enabled() bool {
+	return ta.timeout > 0
+}
+
+// FlushInterval is how often Flush should be called to honor the timeout
+func (ta *TraceAssembler) FlushInterval() time.Duration {
+	if ta.timeout < time.Second {
+		return ta.timeout
+	}
+	return time.Second
+}
+
+// Add adds a normalized trace, or a fragment of trace, sent by the client
+// library with the given receiver tags, to the assembler.
+func (ta *TraceAssembler) Add(t model.Trace, tags receiverTags, now time.Time) {
+	if !ta.Enabled() || len(t) == 0 {
+		ta.out(t, tags)
+		return
+	}
+
+	traceID := t[0].TraceKey()
+	elem, ok := ta.pending[traceID]
+	if !ok {
+		if hasRoot(t) || len(t) > ta.maxSpans {
+			// the whole trace at once, the common case
+			ta.out(t, tags)
+			return
+		}
+		pt := &pendingTrace{
+			traceID:  traceID,
+			tags:     tags,
+			spanIDs:  make(map[uint64]struct{}, len(t)),
+			deadline: now.Add(ta.timeout),
+		}
+		elem = ta.order.PushBack(pt)
+		ta.pending[traceID] = elem
+	}
+
+	pt := elem.Value.(*pendingTrace)
+	for i := range t {
+		// clients may send a fragment again, when retrying
+		if _, ok := pt.spanIDs[t[i].SpanID]; ok {
+			continue
+		}
+		pt.spanIDs[t[i].SpanID] = struct{}{}
+		pt.trace = append(pt.trace, t[i])
+		ta.spans++
+	}
+
+	if hasRoot(pt.trace) {
+		ta.stats.TracesAssembled++
+		ta.remove(elem)
+		ta.out(pt.trace, pt.tags)
+	}
+
+	// memory bound: flush the oldest traces, even if incomplete
+	for ta.spans > ta.maxSpans && ta.order.Len() > 0 {
+		ta.stats.TracesEvicted++
+		ta.flush(ta.order.Front())
+	}
+}
+
+// Flush flushes the incomplete traces which reached the timeout
+func (ta *TraceAssembler) Flush(now time.Time) {
+	for e := ta.order.Front(); e != nil; e = ta.order.Front() {
+		if now.Before(e.Value.(*pendingTrace).deadline) {
+			break
+		}
+		ta.stats.TracesIncomplete++
+		ta.flush(e)
+	}
+
+	if now.Sub(ta.lastStats) >= processStatsInterval {
+		ta.logStats()
+		ta.lastStats = now
+	}
+}
+
+// FlushAll flushes all the buffered traces, whether they are complete or not
+func (ta *TraceAssembler) FlushAll() {
+	for e := ta.order.Front(); e != nil; e = ta.order.Front() {
+		ta.stats.TracesIncomplete++
+		ta.flush(e)
+	}
+}
+
+func (ta *TraceAssembler) flush(e *list.Element) {
+	ta.remove(e)
+	pt := e.Value.(*pendingTrace)
+	ta.out(pt.trace, pt.tags)
+}
+
+func (ta *TraceAssembler) remove(e *list.Element) {
+	pt := ta.order.Remove(e).(*pendingTrace)
+	delete(ta.pending, pt.traceID)
+	ta.spans -= len(pt.trace)
+}
+
+func (ta *TraceAssembler) logStats() {
+	ta.stats.SpansBuffered = int64(ta.spans)
+
+	statsd.Client.Count("datadog.trace_agent.assembler.traces_assembled", ta.stats.TracesAssembled, nil, 1)
+	statsd.Client.Count("datadog.trace_agent.assembler.traces_incomplete", ta.stats.TracesIncomplete, nil, 1)
+	statsd.Client.Count("datadog.trace_agent.assembler.traces_evicted", ta.stats.TracesEvicted, nil, 1)
+	statsd.Client.Gauge("datadog.trace_agent.assembler.spans_buffered", float64(ta.stats.SpansBuffered), nil, 1)
+
+	updateAssemblerStats(ta.stats)
+	if ta.stats.TracesIncomplete > 0 || ta.stats.TracesEvicted > 0 {
+		log.Infof("trace assembler flushed %d incomplete traces after timeout, %d to free memory ; assembled %d traces",
+			ta.stats.TracesIncomplete, ta.stats.TracesEvicted, ta.stats.TracesAssembled)
+	}
+
+	ta.stats = assemblerStats{}
+}
+
+// hasRoot tells if a trace, or a fragment of trace, contains its root span.
+// Fragments whose top spans all have a parent are waiting for it, even if it
+// is in another service: they are held until it arrives or the timeout fires.
+func hasRoot(t model.Trace) bool {
+	for i := range t {
+		if t[i].ParentID == 0 {
+			return true
+		}
+	}
+	return false
+}
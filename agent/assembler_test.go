@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func newTestAssembler(timeout time.Duration, maxSpans int) (*TraceAssembler, *[]model.Trace) {
+	conf := config.NewDefaultAgentConfig()
+	conf.AssemblerTimeout = timeout
+	conf.AssemblerMaxSpans = maxSpans
+
+	var out []model.Trace
+	ta := NewTraceAssembler(conf, func(t model.Trace, _ receiverTags) {
+		out = append(out, t)
+	})
+	return ta, &out
+}
+
+func testFragmentSpan(traceID, spanID, parentID uint64) model.Span {
+	return model.Span{TraceID: traceID, SpanID: spanID, ParentID: parentID, Service: "mcnulty", Name: "query"}
+}
+
+func TestTraceAssemblerDisabled(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(0, 100)
+	assert.False(ta.Enabled())
+
+	ta.Add(model.Trace{testFragmentSpan(1, 2, 1)}, receiverTags{}, time.Now())
+	assert.Len(*out, 1)
+}
+
+func TestTraceAssemblerComplete(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(time.Second, 100)
+	now := time.Now()
+
+	// a complete trace is passed through
+	ta.Add(model.Trace{testFragmentSpan(1, 1, 0), testFragmentSpan(1, 2, 1)}, receiverTags{}, now)
+	assert.Len(*out, 1)
+
+	// fragments are buffered until the root arrives: here a single child span,
+	// then the subtree it belongs to
+	ta.Add(model.Trace{testFragmentSpan(2, 4, 3)}, receiverTags{}, now)
+	assert.Len(*out, 1)
+	ta.Add(model.Trace{testFragmentSpan(2, 3, 2), testFragmentSpan(2, 4, 3)}, receiverTags{}, now) // sent again, by a retrying client
+	assert.Len(*out, 1)
+	assert.Equal(2, ta.spans)
+
+	ta.Add(model.Trace{testFragmentSpan(2, 2, 0)}, receiverTags{}, now)
+	assert.Len(*out, 2)
+	assert.Len((*out)[1], 3)
+	assert.Equal(uint64(2), (*out)[1].GetRoot().SpanID)
+	assert.Equal(0, ta.spans)
+	assert.Len(ta.pending, 0)
+	assert.Equal(int64(1), ta.stats.TracesAssembled)
+}
+
+func TestTraceAssemblerRemoteParent(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(time.Second, 100)
+	now := time.Now()
+
+	// the spans of a downstream service, whose parent is in another process,
+	// are held like any fragment, and merged with the ones arriving later
+	ta.Add(model.Trace{testFragmentSpan(1, 2, 1), testFragmentSpan(1, 3, 2)}, receiverTags{}, now)
+	ta.Add(model.Trace{testFragmentSpan(1, 4, 3)}, receiverTags{}, now)
+	assert.Len(*out, 0)
+
+	// until the timeout
+	ta.Flush(now.Add(time.Second))
+	if !assert.Len(*out, 1) {
+		t.FailNow()
+	}
+	assert.Len((*out)[0], 3)
+	assert.Equal(uint64(2), (*out)[0].GetRoot().SpanID)
+	assert.Equal(int64(0), ta.stats.TracesAssembled)
+	assert.Equal(int64(1), ta.stats.TracesIncomplete)
+}
+
+func TestTraceAssemblerTimeout(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(time.Second, 100)
+	now := time.Now()
+
+	ta.Add(model.Trace{testFragmentSpan(1, 2, 1)}, receiverTags{}, now)
+	ta.Add(model.Trace{testFragmentSpan(2, 3, 2)}, receiverTags{}, now.Add(500*time.Millisecond))
+
+	ta.Flush(now.Add(999 * time.Millisecond))
+	assert.Len(*out, 0)
+
+	ta.Flush(now.Add(time.Second))
+	assert.Len(*out, 1)
+	assert.Equal(uint64(1), (*out)[0][0].TraceID)
+
+	ta.FlushAll()
+	assert.Len(*out, 2)
+	assert.Equal(uint64(2), (*out)[1][0].TraceID)
+	assert.Equal(int64(2), ta.stats.TracesIncomplete)
+	assert.Equal(0, ta.spans)
+}
+
+func TestTraceAssemblerMaxSpans(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(time.Second, 3)
+	now := time.Now()
+
+	ta.Add(model.Trace{testFragmentSpan(1, 2, 1), testFragmentSpan(1, 3, 1)}, receiverTags{}, now)
+	ta.Add(model.Trace{testFragmentSpan(2, 5, 4)}, receiverTags{}, now)
+	assert.Len(*out, 0)
+
+	// the oldest trace is flushed to make room
+	ta.Add(model.Trace{testFragmentSpan(3, 7, 6)}, receiverTags{}, now)
+	assert.Len(*out, 1)
+	assert.Equal(uint64(1), (*out)[0][0].TraceID)
+	assert.Equal(2, ta.spans)
+	assert.Equal(int64(1), ta.stats.TracesEvicted)
+
+	// fragments bigger than the limit are not buffered at all
+	ta.Add(model.Trace{testFragmentSpan(4, 9, 8), testFragmentSpan(4, 10, 8), testFragmentSpan(4, 11, 8), testFragmentSpan(4, 12, 8)}, receiverTags{}, now)
+	assert.Len(*out, 2)
+	assert.Equal(2, ta.spans)
+}
+
+func TestTraceAssemblerTimeoutCap(t *testing.T) {
+	conf := config.NewDefaultAgentConfig()
+	conf.AssemblerTimeout = time.Hour
+	ta := NewTraceAssembler(conf, func(model.Trace, receiverTags) {})
+	assert.Equal(t, conf.BucketInterval, ta.timeout)
+	assert.Equal(t, time.Second, ta.FlushInterval())
+}
+
+func TestTraceAssemblerTraceIDHigh(t *testing.T) {
+	assert := assert.New(t)
+	ta, out := newTestAssembler(time.Second, 100)
+	now := time.Now()
+
+	// 128-bit trace IDs sharing their lower bits are different traces
+	s1, s2 := testFragmentSpan(1, 2, 1), testFragmentSpan(1, 3, 1)
+	s1.TraceIDHigh, s2.TraceIDHigh = 10, 20
+	ta.Add(model.Trace{s1}, receiverTags{}, now)
+	ta.Add(model.Trace{s2}, receiverTags{}, now)
+	assert.Len(ta.pending, 2)
+
+	root := testFragmentSpan(1, 1, 0)
+	root.TraceIDHigh = 20
+	ta.Add(model.Trace{root}, receiverTags{}, now)
+	if !assert.Len(*out, 1) {
+		t.FailNow()
+	}
+	assert.Len((*out)[0], 2)
+	for _, s := range (*out)[0] {
+		assert.Equal(uint64(20), s.TraceIDHigh)
+	}
+}
+
+func TestTraceAssemblerReceiverTags(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.NewDefaultAgentConfig()
+	conf.AssemblerTimeout = time.Second
+	conf.AssemblerMaxSpans = 100
+	var out []receiverTags
+	ta := NewTraceAssembler(conf, func(_ model.Trace, tags receiverTags) {
+		out = append(out, tags)
+	})
+	now := time.Now()
+
+	python, ruby := receiverTags{Lang: "python"}, receiverTags{Lang: "ruby"}
+	ta.Add(model.Trace{testFragmentSpan(1, 1, 0)}, python, now)
+	ta.Add(model.Trace{testFragmentSpan(2, 3, 2), testFragmentSpan(2, 4, 2)}, ruby, now)
+	ta.Add(model.Trace{testFragmentSpan(2, 2, 0)}, python, now)
+	ta.Add(model.Trace{testFragmentSpan(3, 6, 5), testFragmentSpan(3, 7, 5)}, ruby, now)
+	ta.FlushAll()
+
+	// assembled and flushed traces are accounted to the sender of their first fragment
+	assert.Equal([]receiverTags{python, ruby, ruby}, out)
+}
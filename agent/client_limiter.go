@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+)
+
+const (
+	// clientLimiterMaxClients caps the number of clients we keep track of, so
+	// that clients forging identities cannot make us use too much memory.
+	// Beyond it, new clients share the bucket of clientOther.
+	clientLimiterMaxClients = 1000
+	clientOther             = "_other"
+	// clientLocal identifies clients without a remote address, such as the
+	// ones connecting through the Unix socket
+	clientLocal = "local"
+)
+
+// clientLimiter limits the number of spans per second each client may send,
+// with a token bucket per client, so that a single noisy client cannot make
+// us drop the traces of all the others.
+type clientLimiter struct {
+	rate  float64 // spans per second, if 0 clients are not limited
+	burst float64 // size of the buckets
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	stats   map[string]clientLimitStats
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// clientLimitStats contains stats about a client which went over its limit
+type clientLimitStats struct {
+	// TracesRateLimited is the number of traces of the client dropped because it went over its limit
+	TracesRateLimited int64
+	// SpansRateLimited is the number of spans in those traces
+	SpansRateLimited int64
+}
+
+func newClientLimiter(conf *config.AgentConfig) *clientLimiter {
+	burst := float64(conf.ReceiverClientMaxBurst)
+	if burst <= 0 {
+		burst = conf.ReceiverClientMaxSPS
+	}
+	return &clientLimiter{
+		rate:    conf.ReceiverClientMaxSPS,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		stats:   make(map[string]clientLimitStats),
+	}
+}
+
+// Enabled tells if clients are limited at all
+func (cl *clientLimiter) Enabled() bool {
+	return cl.rate > 0
+}
+
+// allow tells if client may send a trace of the given number of spans, and
+// counts it against its limit if so. A trace bigger than the bucket is
+// allowed when the bucket is full, the client going into debt.
+func (cl *clientLimiter) allow(client string, spans int, now time.Time) bool {
+	if !cl.Enabled() {
+		return true
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	b, ok := cl.buckets[client]
+	if !ok {
+		if len(cl.buckets) >= clientLimiterMaxClients {
+			client = clientOther
+			b = cl.buckets[client]
+		}
+		if b == nil {
+			b = &tokenBucket{tokens: cl.burst, last: now}
+			cl.buckets[client] = b
+		}
+	}
+	cl.refill(b, now)
+
+	n := float64(spans)
+	if b.tokens < n && b.tokens < cl.burst {
+		s := cl.stats[client]
+		s.TracesRateLimited++
+		s.SpansRateLimited += int64(spans)
+		cl.stats[client] = s
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+func (cl *clientLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * cl.rate
+		b.last = now
+	}
+	if b.tokens > cl.burst {
+		b.tokens = cl.burst
+	}
+}
+
+// swapStats returns the stats of the clients which went over their limit
+// since the last call, and forgets about the clients whose bucket is full
+// again, to make room for new ones.
+func (cl *clientLimiter) swapStats(now time.Time) map[string]clientLimitStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for client, b := range cl.buckets {
+		if cl.refill(b, now); b.tokens >= cl.burst {
+			delete(cl.buckets, client)
+		}
+	}
+
+	stats := cl.stats
+	cl.stats = make(map[string]clientLimitStats)
+	return stats
+}
+
+// clientID identifies the client which sent a request, with the configured
+// header if it set it, or with its remote IP.
+func (r *HTTPReceiver) clientID(req *http.Request) string {
+	if h := r.conf.ReceiverClientIDHeader; h != "" {
+		if id := req.Header.Get(h); id != "" {
+			return id
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		return clientLocal
+	}
+	return host
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func newTestClientLimiter(sps float64, burst int) *clientLimiter {
+	conf := config.NewDefaultAgentConfig()
+	conf.ReceiverClientMaxSPS = sps
+	conf.ReceiverClientMaxBurst = burst
+	return newClientLimiter(conf)
+}
+
+func TestClientLimiterDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	cl := newTestClientLimiter(0, 0)
+	assert.False(cl.Enabled())
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.True(cl.allow("10.0.0.1", 1000, now))
+	}
+	assert.Len(cl.swapStats(now), 0)
+}
+
+func TestClientLimiterAllow(t *testing.T) {
+	assert := assert.New(t)
+
+	cl := newTestClientLimiter(10, 20)
+	now := time.Now()
+
+	// the burst, and not more
+	assert.True(cl.allow("noisy", 15, now))
+	assert.True(cl.allow("noisy", 5, now))
+	assert.False(cl.allow("noisy", 1, now))
+	assert.False(cl.allow("noisy", 3, now))
+
+	// other clients are not impacted
+	assert.True(cl.allow("quiet", 20, now))
+
+	// tokens come back with time
+	now = now.Add(500 * time.Millisecond)
+	assert.True(cl.allow("noisy", 5, now))
+	assert.False(cl.allow("noisy", 1, now))
+
+	stats := cl.swapStats(now)
+	assert.Equal(map[string]clientLimitStats{"noisy": {TracesRateLimited: 3, SpansRateLimited: 5}}, stats)
+	assert.Len(cl.swapStats(now), 0)
+}
+
+func TestClientLimiterBigTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	cl := newTestClientLimiter(10, 0)
+	now := time.Now()
+
+	// traces bigger than the bucket go through when it's full, the client
+	// then has to wait for its debt to be paid back
+	assert.True(cl.allow("client", 30, now))
+	assert.False(cl.allow("client", 1, now.Add(2*time.Second)))
+	assert.True(cl.allow("client", 1, now.Add(3*time.Second)))
+}
+
+func TestClientLimiterMaxClients(t *testing.T) {
+	assert := assert.New(t)
+
+	cl := newTestClientLimiter(10, 10)
+	now := time.Now()
+
+	for i := 0; i < clientLimiterMaxClients; i++ {
+		assert.True(cl.allow(fmt.Sprintf("client-%d", i), 10, now))
+	}
+	// new clients share a bucket
+	assert.True(cl.allow("new-1", 10, now))
+	assert.False(cl.allow("new-2", 10, now))
+	assert.Equal(map[string]clientLimitStats{clientOther: {TracesRateLimited: 1, SpansRateLimited: 10}}, cl.swapStats(now))
+
+	// once their bucket is full again, clients are forgotten
+	assert.Len(cl.buckets, clientLimiterMaxClients+1)
+	cl.swapStats(now.Add(time.Second))
+	assert.Len(cl.buckets, 0)
+	assert.True(cl.allow("new-2", 10, now.Add(time.Second)))
+	assert.Contains(cl.buckets, "new-2")
+}
+
+func TestReceiverClientID(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	r := newTestReceiverFromConfig(conf)
+
+	req, _ := http.NewRequest("POST", "/v0.4/traces", nil)
+	req.RemoteAddr = "10.0.3.7:52433"
+	req.Header.Set("X-Client", "billing")
+	assert.Equal("10.0.3.7", r.clientID(req))
+
+	conf.ReceiverClientIDHeader = "X-Client"
+	assert.Equal("billing", r.clientID(req))
+
+	req.Header.Del("X-Client")
+	req.RemoteAddr = "[::1]:52433"
+	assert.Equal("::1", r.clientID(req))
+
+	req.RemoteAddr = "@"
+	assert.Equal(clientLocal, r.clientID(req))
+}
+
+func TestReceiverClientRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.ReceiverClientMaxSPS = 1
+	conf.ReceiverClientMaxBurst = 2
+	conf.ReceiverClientIDHeader = "X-Client"
+	r := newTestReceiverFromConfig(conf)
+
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v04, r.handleTraces)))
+	defer server.Close()
+
+	traces := model.Traces{
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+	}
+	data, err := json.Marshal(traces)
+	assert.Nil(err)
+
+	post := func(client string) []traceRejection {
+		req, err := http.NewRequest("POST", server.URL, bytes.NewReader(data))
+		assert.Nil(err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Client", client)
+		req.Header.Set(RejectionDetailsHeader, "true")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		var body struct {
+			Rejected []traceRejection `json:"rejected"`
+		}
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&body))
+		return body.Rejected
+	}
+
+	rejected := post("noisy")
+	if assert.Len(rejected, 1) {
+		assert.Equal(2, rejected[0].Index)
+		assert.Equal("client-rate-limited", rejected[0].Error)
+	}
+	assert.Len(post("noisy"), 3)
+	assert.Len(post("quiet"), 1)
+	assert.Len(r.traces, 4)
+
+	stats := r.clientLimiter.swapStats(time.Now())
+	assert.Equal(int64(4), stats["noisy"].TracesRateLimited)
+	assert.Equal(int64(1), stats["quiet"].TracesRateLimited)
+}
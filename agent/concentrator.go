@@ -6,6 +6,7 @@ import (
 
 	log "github.com/cihub/seelog"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 )
@@ -17,6 +18,18 @@ import (
 type Concentrator struct {
 	aggregators []string
 	bsize       int64
+	// if set, spans which are neither top-level nor measured only contribute
+	// to the sublayer stats of their trace
+	measuredOnly bool
+	// maxErrorTypes is the maximum number of distinct error types counted
+	// for a grain of a bucket
+	maxErrorTypes int
+	// apdex holds the thresholds against which the Apdex counts of top-level
+	// spans are computed, if not nil
+	apdex *model.ApdexThresholds
+	// maxGrainsPerService is the maximum number of distinct grains of a
+	// service in a bucket, unlimited if 0
+	maxGrainsPerService int
 
 	buckets map[int64]*model.StatsRawBucket // buckets used to aggregate stats per timestamp
 	mu      sync.Mutex
@@ -28,11 +41,35 @@ func NewConcentrator(aggregators []string, bsize int64) *Concentrator {
 		aggregators: aggregators,
 		bsize:       bsize,
 		buckets:     make(map[int64]*model.StatsRawBucket),
+
+		maxErrorTypes:       model.DefaultMaxErrorTypes,
+		maxGrainsPerService: model.DefaultMaxGrainsPerService,
 	}
 	sort.Strings(c.aggregators)
 	return &c
 }
 
+// newApdexThresholds returns the configured Apdex thresholds, or nil if Apdex
+// counts are disabled. Invalid overrides are logged and skipped.
+func newApdexThresholds(conf *config.AgentConfig) *model.ApdexThresholds {
+	if conf.ApdexThreshold <= 0 && len(conf.ApdexOverrides) == 0 {
+		return nil
+	}
+	at := model.NewApdexThresholds(conf.ApdexThreshold.Nanoseconds())
+	for _, o := range conf.ApdexOverrides {
+		if o.Service == "" {
+			log.Errorf("invalid Apdex override %s: no service", o.Name)
+			continue
+		}
+		if o.Threshold <= 0 {
+			log.Errorf("invalid Apdex override %s: the threshold should be a positive number of milliseconds", o.Name)
+			continue
+		}
+		at.Set(o.Service, o.Resource, o.Threshold.Nanoseconds())
+	}
+	return at
+}
+
 // Add appends to the proper stats bucket this trace's statistics
 func (c *Concentrator) Add(t processedTrace) {
 	c.mu.Lock()
@@ -42,13 +79,17 @@ func (c *Concentrator) Add(t processedTrace) {
 		b, ok := c.buckets[btime]
 		if !ok {
 			b = model.NewStatsRawBucket(btime, c.bsize)
+			b.SetMaxErrorTypes(c.maxErrorTypes)
+			b.SetApdexThresholds(c.apdex)
+			b.SetMaxGrainsPerService(c.maxGrainsPerService)
 			c.buckets[btime] = b
 		}
 
-		if t.Root != nil && s.SpanID == t.Root.SpanID && t.Sublayers != nil {
+		isRoot := t.Root != nil && s.SpanID == t.Root.SpanID
+		if isRoot && t.Sublayers != nil {
 			// handle sublayers
 			b.HandleSpan(s, t.Env, c.aggregators, &t.Sublayers)
-		} else {
+		} else if !c.measuredOnly || isRoot || s.FullStats() {
 			b.HandleSpan(s, t.Env, c.aggregators, nil)
 		}
 	}
@@ -58,20 +99,37 @@ func (c *Concentrator) Add(t processedTrace) {
 
 // Flush deletes and returns complete statistic buckets
 func (c *Concentrator) Flush() []model.StatsBucket {
+	return c.flush(false)
+}
+
+// FlushAll deletes and returns all statistic buckets, including the ones
+// still open. It is meant to be called when exiting.
+func (c *Concentrator) FlushAll() []model.StatsBucket {
+	return c.flush(true)
+}
+
+func (c *Concentrator) flush(all bool) []model.StatsBucket {
 	var sb []model.StatsBucket
+	var collapsed map[string]int64
 	now := model.Now()
 
 	c.mu.Lock()
 	for ts, srb := range c.buckets {
-		bucket := srb.Export()
-
 		// always keep one bucket opened
 		// this is a trade-off: we accept slightly late traces (clock skew and stuff)
 		// but we delay flushing by at most 2 buckets
-		if ts > now-2*c.bsize {
+		if !all && ts > now-2*c.bsize {
 			continue
 		}
 
+		for service, n := range srb.CollapsedResources() {
+			if collapsed == nil {
+				collapsed = make(map[string]int64)
+			}
+			collapsed[service] += int64(n)
+		}
+		bucket := srb.Export()
+
 		log.Debugf("flushing bucket %d", ts)
 		for _, d := range bucket.Distributions {
 			statsd.Client.Histogram("datadog.trace_agent.distribution.len", float64(d.Summary.N), nil, 1)
@@ -81,5 +139,13 @@ func (c *Concentrator) Flush() []model.StatsBucket {
 	}
 	c.mu.Unlock()
 
+	if len(sb) > 0 {
+		for service, n := range collapsed {
+			log.Warnf("service %s exceeded its maximum number of grains, %d resources collapsed into resource:%s", service, n, model.OtherResource)
+			statsd.Client.Count("datadog.trace_agent.stats.collapsed_resources", n, []string{"service:" + service}, 1)
+		}
+		updateCollapsedResources(collapsed)
+	}
+
 	return sb
 }
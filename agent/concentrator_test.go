@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -140,3 +141,110 @@ func TestConcentratorStatsCounts(t *testing.T) {
 		assert.Equal(val, int64(count.Value), "Wrong value for count %s", key)
 	}
 }
+
+func TestConcentratorFlushAll(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, testBucketInterval)
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			// buckets still open, kept by Flush
+			testSpan(c, 1, 24, 1, "A1", "resource1", 0),
+			testSpan(c, 2, 12, 0, "A1", "resource1", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+	assert.Len(c.Flush(), 0)
+	assert.Len(c.FlushAll(), 2)
+	assert.Len(c.FlushAll(), 0)
+}
+
+func TestConcentratorMeasuredOnly(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, testBucketInterval)
+	c.measuredOnly = true
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			testSpan(c, 1, 50, 3, "A1", "root", 0),
+			testSpan(c, 2, 10, 3, "A1", "internal", 0),
+			testSpan(c, 3, 10, 3, "A1", "measured", 0),
+			testSpan(c, 4, 10, 3, "A1", "forced", 0),
+		},
+	}
+	for i := 1; i < len(testTrace.Trace); i++ {
+		testTrace.Trace[i].ParentID = 1
+	}
+	testTrace.Trace[2].Metrics = map[string]float64{model.MeasuredKey: 1}
+	testTrace.Trace[3].Meta = map[string]string{model.TraceMetricsKey: "true"}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+	testTrace.Trace.ComputeMeasured()
+
+	c.Add(testTrace)
+	stats := c.FlushAll()
+	if !assert.Len(stats, 1) {
+		t.FailNow()
+	}
+
+	counts := stats[0].Counts
+	for _, resource := range []string{"root", "measured", "forced"} {
+		_, ok := counts["query|hits|env:none,resource:"+resource+",service:A1"]
+		assert.True(ok, "%s should have stats", resource)
+	}
+	_, ok := counts["query|hits|env:none,resource:internal,service:A1"]
+	assert.False(ok, "internal spans should not have stats")
+}
+
+func TestNewApdexThresholds(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.ApdexThreshold = 500 * time.Millisecond
+	conf.ApdexOverrides = []config.ApdexOverride{
+		{Name: "web", Service: "web", Threshold: 100 * time.Millisecond},
+		{Name: "no-service", Resource: "GET /", Threshold: time.Second},
+		{Name: "no-threshold", Service: "db"},
+	}
+	at := newApdexThresholds(conf)
+	if !assert.NotNil(at) {
+		t.FailNow()
+	}
+	assert.Equal(int64(100*time.Millisecond), at.Get("web", "GET /"))
+	assert.Equal(int64(500*time.Millisecond), at.Get("db", "SELECT"))
+	assert.Equal(int64(500*time.Millisecond), at.Get("", "GET /"))
+
+	// off by default
+	assert.Nil(newApdexThresholds(config.NewDefaultAgentConfig()))
+}
+
+func TestConcentratorCollapsedResources(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, testBucketInterval)
+	c.maxGrainsPerService = 1
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			testSpan(c, 1, 24, 3, "A1", "resource1", 0),
+			testSpan(c, 2, 12, 3, "A1", "resource2", 0),
+			testSpan(c, 3, 12, 3, "A1", "resource3", 0),
+			testSpan(c, 4, 12, 3, "A2", "resource1", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+	stats := c.Flush()
+	if !assert.Len(stats, 1) {
+		t.FailNow()
+	}
+	assert.Contains(stats[0].Counts, "query|hits|env:none,resource:_other,service:A1")
+	assert.Equal(map[string]int64{"A1": 2}, publishCollapsedResources())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// PayloadDecoder decodes a request body into dest. It is registered for
+// the media types it understands with RegisterPayloadDecoder.
+type PayloadDecoder func(r io.Reader, dest msgp.Decodable) error
+
+// payloadDecoders maps a media type, without parameters, to its decoder.
+// An empty media type is what clients which do not set Content-Type send.
+var payloadDecoders = map[string]PayloadDecoder{}
+
+// TracesStreamDecoder returns a decoder of the traces of a request body, to
+// decode and process them one at a time. It is registered for the media types
+// it understands with RegisterTracesStreamDecoder, and is then used for traces
+// payloads rather than the PayloadDecoder of the same media type.
+type TracesStreamDecoder func(r io.Reader) (*model.TracesDecoder, error)
+
+// tracesStreamDecoders maps a media type, without parameters, to its decoder
+var tracesStreamDecoders = map[string]TracesStreamDecoder{}
+
+func init() {
+	RegisterPayloadDecoder(decodeMsgpack, "application/msgpack")
+	RegisterPayloadDecoder(decodeJSON, "application/json", "text/json", "")
+	RegisterTracesStreamDecoder(model.NewTracesDecoder, "application/msgpack")
+}
+
+// RegisterPayloadDecoder makes the receiver accept payloads of the given media
+// types, decoding them with dec. It is not thread-safe and has to be called
+// before the receiver starts, typically from an init function.
+func RegisterPayloadDecoder(dec PayloadDecoder, mediaTypes ...string) {
+	for _, mt := range mediaTypes {
+		payloadDecoders[strings.ToLower(mt)] = dec
+	}
+}
+
+// RegisterTracesStreamDecoder makes the receiver decode the traces payloads of
+// the given media types with dec, one trace at a time. Like
+// RegisterPayloadDecoder, it has to be called before the receiver starts.
+func RegisterTracesStreamDecoder(dec TracesStreamDecoder, mediaTypes ...string) {
+	for _, mt := range mediaTypes {
+		tracesStreamDecoders[strings.ToLower(mt)] = dec
+	}
+}
+
+// UnsupportedMediaTypeError is returned when no decoder is registered for
+// the media type of a payload.
+type UnsupportedMediaTypeError struct {
+	MediaType string
+}
+
+// Error returns an error string
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported media type %q", e.MediaType)
+}
+
+// getMediaType returns the media type of a Content-Type header, lowercased
+// and stripped from its parameters, e.g. "application/json; charset=utf-8"
+// gives "application/json".
+func getMediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// be lenient with malformed parameters, the media type itself may be fine
+		mt = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mt
+}
+
+// decodeReceiverPayload decodes a payload with the decoder registered for its
+// content type, returning an *UnsupportedMediaTypeError if there is none.
+func decodeReceiverPayload(r io.Reader, dest msgp.Decodable, contentType string) error {
+	mt := getMediaType(contentType)
+	dec, ok := payloadDecoders[mt]
+	if !ok {
+		return &UnsupportedMediaTypeError{MediaType: mt}
+	}
+	return dec(r, dest)
+}
+
+// getTracesStreamDecoder returns the stream decoder registered for the content
+// type of a traces payload, if any
+func getTracesStreamDecoder(contentType string) (TracesStreamDecoder, bool) {
+	dec, ok := tracesStreamDecoders[getMediaType(contentType)]
+	return dec, ok
+}
+
+func decodeMsgpack(r io.Reader, dest msgp.Decodable) error {
+	return msgp.Decode(r, dest)
+}
+
+func decodeJSON(r io.Reader, dest msgp.Decodable) error {
+	return json.NewDecoder(r).Decode(dest)
+}
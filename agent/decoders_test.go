@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func TestGetMediaType(t *testing.T) {
+	assert := assert.New(t)
+
+	for contentType, mediaType := range map[string]string{
+		"":                                "",
+		"application/json":                "application/json",
+		"application/json; charset=utf-8": "application/json",
+		"Application/MsgPack":             "application/msgpack",
+		"text/json;charset":               "text/json",
+	} {
+		assert.Equal(mediaType, getMediaType(contentType), contentType)
+	}
+}
+
+func TestDecodeReceiverPayloadUnsupported(t *testing.T) {
+	assert := assert.New(t)
+
+	var traces model.Traces
+	err := decodeReceiverPayload(bytes.NewBufferString("[]"), &traces, "application/xml; charset=utf-8")
+	assert.Equal(&UnsupportedMediaTypeError{MediaType: "application/xml"}, err)
+}
+
+func TestRegisterPayloadDecoder(t *testing.T) {
+	assert := assert.New(t)
+	defer delete(payloadDecoders, "application/x-test")
+
+	errTest := errors.New("test decoder called")
+	RegisterPayloadDecoder(func(r io.Reader, dest msgp.Decodable) error {
+		return errTest
+	}, "application/x-test")
+
+	var traces model.Traces
+	err := decodeReceiverPayload(bytes.NewBufferString("[]"), &traces, "application/x-test")
+	assert.Equal(errTest, err)
+}
+
+func TestRegisterTracesStreamDecoder(t *testing.T) {
+	assert := assert.New(t)
+	defer delete(tracesStreamDecoders, "application/x-test")
+
+	_, ok := getTracesStreamDecoder("application/json")
+	assert.False(ok)
+	_, ok = getTracesStreamDecoder("application/msgpack; charset=utf-8")
+	assert.True(ok)
+
+	errTest := errors.New("test decoder called")
+	RegisterTracesStreamDecoder(func(r io.Reader) (*model.TracesDecoder, error) {
+		return nil, errTest
+	}, "Application/X-Test")
+	dec, ok := getTracesStreamDecoder("application/x-test")
+	if assert.True(ok) {
+		_, err := dec(bytes.NewBufferString("[]"))
+		assert.Equal(errTest, err)
+	}
+}
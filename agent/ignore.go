@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+// TraceIgnorer drops the traces whose root span matches any of the configured
+// rules, such as the ones of health checks, before they reach the stats and
+// the sampler.
+//
+// It is not thread-safe: it is meant to be used from the agent main loop only.
+type TraceIgnorer struct {
+	rules []*ignoreRule
+}
+
+type ignoreRule struct {
+	name  string // field:regex, identifies the rule in stats
+	field spanField
+	re    *regexp.Regexp
+
+	dropped  int64 // traces dropped since we started
+	reported int64 // dropped, as of the last stats
+}
+
+// NewTraceIgnorer creates a new TraceIgnorer from the configured rules. Invalid
+// rules are logged and skipped.
+func NewTraceIgnorer(conf *config.AgentConfig) *TraceIgnorer {
+	ti := &TraceIgnorer{}
+	for name, regexes := range conf.Ignore {
+		field, err := parseSpanField(name)
+		if err != nil {
+			log.Errorf("invalid ignore rule: %v", err)
+			continue
+		}
+
+		for _, v := range regexes {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				log.Errorf("invalid ignore rule regex %q: %v", v, err)
+				continue
+			}
+			ti.rules = append(ti.rules, &ignoreRule{
+				name:  field.String() + ":" + v,
+				field: field,
+				re:    re,
+			})
+		}
+	}
+	// the config is a map, keep the rules in a stable order
+	sort.Slice(ti.rules, func(i, j int) bool { return ti.rules[i].name < ti.rules[j].name })
+	return ti
+}
+
+// Ignore tells if a trace should be dropped, given its root span, and counts
+// it against the first rule it matches if so.
+func (ti *TraceIgnorer) Ignore(root *model.Span) bool {
+	for _, r := range ti.rules {
+		if r.match(root) {
+			r.dropped++
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ignoreRule) match(s *model.Span) bool {
+	v, ok := r.field.get(s)
+	return ok && r.re.MatchString(v)
+}
+
+// logStats submits the number of traces each rule dropped since the last
+// call, and publishes the totals.
+func (ti *TraceIgnorer) logStats() {
+	if len(ti.rules) == 0 {
+		return
+	}
+
+	dropped := make(map[string]int64, len(ti.rules))
+	for _, r := range ti.rules {
+		dropped[r.name] = r.dropped
+		if n := r.dropped - r.reported; n > 0 {
+			// regexes are not fit for tags, only tag the field
+			tags := []string{"field:" + r.field.name}
+			if r.field.metaKey != "" {
+				tags = append(tags, "meta_key:"+r.field.metaKey)
+			}
+			statsd.Client.Count("datadog.trace_agent.ignore.traces_dropped", n, tags, 1)
+			r.reported = r.dropped
+		}
+	}
+	updateIgnoreStats(dropped)
+}
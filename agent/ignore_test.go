@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceIgnorer(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.Ignore = map[string][]string{
+		"service":       []string{"^healthcheck$"},
+		"resource":      []string{"GET /ping", "GET /health"},
+		"meta.http.url": []string{"/healthz$"},
+		"duration":      []string{".*"},   // invalid field
+		"name":          []string{"[a-z"}, // invalid regex
+	}
+	ti := NewTraceIgnorer(conf)
+	assert.Len(ti.rules, 4)
+
+	for _, s := range []model.Span{
+		{Service: "healthcheck", Name: "http.request", Resource: "GET /"},
+		{Service: "web", Name: "http.request", Resource: "GET /ping"},
+		{Service: "web", Name: "http.request", Resource: "GET /ping"},
+		{Service: "web", Name: "http.request", Resource: "GET /", Meta: map[string]string{"http.url": "http://web/healthz"}},
+	} {
+		assert.True(ti.Ignore(&s), "%v should be ignored", s)
+	}
+
+	for _, s := range []model.Span{
+		{Service: "healthchecks", Name: "http.request", Resource: "GET /"},
+		{Service: "web", Name: "http.request", Resource: "GET /users"},
+		{Service: "web", Name: "http.request", Resource: "GET /", Meta: map[string]string{"http.url": "http://web/healthz/users"}},
+		{Service: "web", Name: "GET /ping", Resource: "GET /"},
+	} {
+		assert.False(ti.Ignore(&s), "%v should not be ignored", s)
+	}
+
+	dropped := make(map[string]int64)
+	for _, r := range ti.rules {
+		dropped[r.name] = r.dropped
+	}
+	assert.Equal(map[string]int64{
+		"service:^healthcheck$":   1,
+		"resource:GET /ping":      2,
+		"resource:GET /health":    0,
+		"meta.http.url:/healthz$": 1,
+	}, dropped)
+
+	ti.logStats()
+	assert.Equal(dropped, publishIgnoreStats())
+	for _, r := range ti.rules {
+		assert.Equal(r.dropped, r.reported)
+	}
+}
+
+func TestAgentProcessIgnore(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "key"
+	conf.Ignore = map[string][]string{"resource": []string{"^GET /ping$"}}
+	a := NewAgent(conf)
+
+	now := model.Now()
+	trace := model.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /ping", Start: now - 1000, Duration: 1000},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "web", Name: "sql.query", Resource: "SELECT 1", Start: now - 500, Duration: 100},
+	}
+	a.Process(trace, receiverTags{})
+	a.processing.Wait()
+
+	assert.Len(a.Concentrator.FlushAll(), 0)
+	assert.Equal(int64(1), a.Ignorer.rules[0].dropped)
+}
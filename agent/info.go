@@ -5,6 +5,7 @@ import (
 	"expvar" // automatically publish `/debug/vars` on HTTP port
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -20,10 +21,15 @@ import (
 
 var (
 	infoMu              sync.RWMutex
-	infoReceiverStats   receiverStats // only for the last minute
-	infoEndpointStats   endpointStats // only for the last minute
+	infoReceiverStats   receiverStats               // only for the last minute
+	infoReceiverTags    []tagStats                  // only for the last minute
+	infoClientLimits    map[string]clientLimitStats // only for the last minute
+	infoEndpointStats   endpointStats               // only for the last minute
 	infoWatchdogInfo    watchdog.Info
 	infoSamplerInfo     samplerInfo
+	infoAssemblerStats  assemblerStats   // only for the last minute
+	infoIgnoreStats     map[string]int64 // traces dropped by ignore rule, since start
+	infoCollapsed       map[string]int64 // resources collapsed by service, in the last flushed buckets
 	infoPreSamplerStats sampler.PreSamplerStats
 	infoStart           = time.Now()
 	infoOnce            sync.Once
@@ -42,17 +48,30 @@ const (
   Mem alloc: {{.Status.MemStats.Alloc}} bytes
 
   Hostname: {{.Status.Config.HostName}}
-  Receiver: {{.Status.Config.ReceiverHost}}:{{.Status.Config.ReceiverPort}}
-  API Endpoint: {{.Status.Config.APIEndpoint}}
+{{if .Status.Config.ReceiverPort}}  Receiver: {{.Status.Config.ReceiverHost}}:{{.Status.Config.ReceiverPort}}
+{{end}}{{if .Status.Config.ReceiverTLSPort}}  Receiver (TLS): {{.Status.Config.ReceiverHost}}:{{.Status.Config.ReceiverTLSPort}}
+{{end}}{{if .Status.Config.ReceiverSocket}}  Receiver socket: {{.Status.Config.ReceiverSocket}}
+{{end}}  API Endpoint: {{.Status.Config.APIEndpoint}}
 
   Bytes received (1 min): {{add .Status.Receiver.TracesBytes .Status.Receiver.ServicesBytes}}
   Traces received (1 min): {{.Status.Receiver.TracesReceived}}
   Spans received (1 min): {{.Status.Receiver.SpansReceived}}
 {{if gt .Status.Receiver.TracesDropped 0}}  WARNING: Traces dropped (1 min): {{.Status.Receiver.TracesDropped}}
 {{end}}{{if gt .Status.Receiver.SpansDropped 0}}  WARNING: Spans dropped (1 min): {{.Status.Receiver.SpansDropped}}
+{{end}}{{if gt .Status.Receiver.TracesRateLimited 0}}  WARNING: Traces rejected, clients asked to retry (1 min): {{.Status.Receiver.TracesRateLimited}}
+{{end}}{{range $client, $stats := .Status.ClientLimits}}  WARNING: Client {{$client}} over its rate limit (1 min): {{$stats.TracesRateLimited}} traces, {{$stats.SpansRateLimited}} spans dropped
+{{end}}{{if gt .Status.Assembler.TracesAssembled 0}}  Traces assembled from several payloads (1 min): {{.Status.Assembler.TracesAssembled}}
+{{end}}{{if gt .Status.Assembler.TracesIncomplete 0}}  WARNING: Incomplete traces flushed after timeout (1 min): {{.Status.Assembler.TracesIncomplete}}
+{{end}}{{if gt .Status.Assembler.TracesEvicted 0}}  WARNING: Incomplete traces flushed to free memory (1 min): {{.Status.Assembler.TracesEvicted}}
+{{end}}{{range $rule, $dropped := .Status.Ignore}}{{if gt $dropped 0}}  Traces ignored by rule {{$rule}}: {{$dropped}}
+{{end}}{{end}}{{range $service, $n := .Status.Collapsed}}  WARNING: Resources of service {{$service}} collapsed into resource:_other (last flush): {{$n}}
 {{end}}{{if lt .Status.PreSampler.Rate 1.0}}  WARNING: Pre-sampling traces: {{percent .Status.PreSampler.Rate}} %
 {{end}}{{if .Status.PreSampler.Error}}  WARNING: Pre-sampler: {{.Status.PreSampler.Error}}
-{{end}}
+{{end}}{{range $priority, $tps := .Status.Sampler.Stats.PriorityTPS}}  Traces with sampling priority {{$priority}}: {{printf "%.1f" $tps}}/s, kept {{printf "%.1f" (index $.Status.Sampler.Stats.PriorityKeptTPS $priority)}}/s
+{{end}}{{if .Status.ReceiverTags}}
+  {{printf "%-12s %-14s %-14s %8s %8s %8s %8s" "Lang" "Lang version" "Tracer" "Traces" "Spans" "Dropped" "Errors"}}
+{{range .Status.ReceiverTags}}  {{printf "%-12s %-14s %-14s %8d %8d %8d %8d" (or .Lang "unknown") (or .LangVersion "-") (or .TracerVersion "-") .TracesReceived .SpansReceived .TracesDropped .PayloadsDecodingError}}
+{{end}}{{end}}
   Bytes sent (1 min): {{add .Status.Endpoint.TracesBytes .Status.Endpoint.ServicesBytes}}
   Traces sent (1 min): {{.Status.Endpoint.TracesCount}}
   Stats sent (1 min): {{.Status.Endpoint.TracesStats}}
@@ -64,7 +83,7 @@ const (
 {{.Program}}
 {{.Banner}}
 
-  Not running (port {{.ReceiverPort}})
+  Not running ({{.Address}})
 
 `
 	infoErrorTmplSrc = `{{.Banner}}
@@ -94,6 +113,71 @@ func publishReceiverStats() interface{} {
 	return rs
 }
 
+func updateReceiverTagStats(ts []tagStats) {
+	infoMu.Lock()
+	infoReceiverTags = ts
+	infoMu.Unlock()
+}
+
+func publishReceiverTagStats() interface{} {
+	infoMu.RLock()
+	ts := infoReceiverTags
+	infoMu.RUnlock()
+	return ts
+}
+
+func updateClientLimitStats(cs map[string]clientLimitStats) {
+	infoMu.Lock()
+	infoClientLimits = cs
+	infoMu.Unlock()
+}
+
+func publishClientLimitStats() interface{} {
+	infoMu.RLock()
+	cs := infoClientLimits
+	infoMu.RUnlock()
+	return cs
+}
+
+func updateAssemblerStats(as assemblerStats) {
+	infoMu.Lock()
+	infoAssemblerStats = as
+	infoMu.Unlock()
+}
+
+func publishAssemblerStats() interface{} {
+	infoMu.RLock()
+	as := infoAssemblerStats
+	infoMu.RUnlock()
+	return as
+}
+
+func updateIgnoreStats(is map[string]int64) {
+	infoMu.Lock()
+	infoIgnoreStats = is
+	infoMu.Unlock()
+}
+
+func publishIgnoreStats() interface{} {
+	infoMu.RLock()
+	is := infoIgnoreStats
+	infoMu.RUnlock()
+	return is
+}
+
+func updateCollapsedResources(c map[string]int64) {
+	infoMu.Lock()
+	infoCollapsed = c
+	infoMu.Unlock()
+}
+
+func publishCollapsedResources() interface{} {
+	infoMu.RLock()
+	c := infoCollapsed
+	infoMu.RUnlock()
+	return c
+}
+
 func updateEndpointStats(es endpointStats) {
 	infoMu.Lock()
 	infoEndpointStats = es
@@ -186,6 +270,11 @@ func initInfo(conf *config.AgentConfig) error {
 		expvar.Publish("uptime", expvar.Func(publishUptime))
 		expvar.Publish("version", expvar.Func(publishVersion))
 		expvar.Publish("receiver", expvar.Func(publishReceiverStats))
+		expvar.Publish("receiver_tags", expvar.Func(publishReceiverTagStats))
+		expvar.Publish("client_limits", expvar.Func(publishClientLimitStats))
+		expvar.Publish("assembler", expvar.Func(publishAssemblerStats))
+		expvar.Publish("ignore", expvar.Func(publishIgnoreStats))
+		expvar.Publish("collapsed", expvar.Func(publishCollapsedResources))
 		expvar.Publish("endpoint", expvar.Func(publishEndpointStats))
 		expvar.Publish("sampler", expvar.Func(publishSamplerInfo))
 		expvar.Publish("watchdog", expvar.Func(publishWatchdogInfo))
@@ -235,12 +324,18 @@ type StatusInfo struct {
 	MemStats struct {
 		Alloc uint64
 	} `json:"memstats"`
-	Version    infoVersion             `json:"version"`
-	Receiver   receiverStats           `json:"receiver"`
-	Endpoint   endpointStats           `json:"endpoint"`
-	Watchdog   watchdog.Info           `json:"watchdog"`
-	PreSampler sampler.PreSamplerStats `json:"presampler"`
-	Config     config.AgentConfig      `json:"config"`
+	Version      infoVersion                 `json:"version"`
+	Receiver     receiverStats               `json:"receiver"`
+	ReceiverTags []tagStats                  `json:"receiver_tags"`
+	ClientLimits map[string]clientLimitStats `json:"client_limits"`
+	Assembler    assemblerStats              `json:"assembler"`
+	Ignore       map[string]int64            `json:"ignore"`
+	Collapsed    map[string]int64            `json:"collapsed"`
+	Endpoint     endpointStats               `json:"endpoint"`
+	Watchdog     watchdog.Info               `json:"watchdog"`
+	PreSampler   sampler.PreSamplerStats     `json:"presampler"`
+	Sampler      samplerInfo                 `json:"sampler"`
+	Config       config.AgentConfig          `json:"config"`
 }
 
 func getProgramBanner(version string) (string, string) {
@@ -277,8 +372,19 @@ func getProgramBanner(version string) (string, string) {
 //   Spans received (1 min): 360
 //   WARNING: Traces dropped (1 min): 5
 //   WARNING: Spans dropped (1 min): 10
+//   WARNING: Client 10.0.3.7 over its rate limit (1 min): 3 traces, 1200 spans dropped
+//   Traces assembled from several payloads (1 min): 12
+//   WARNING: Incomplete traces flushed after timeout (1 min): 2
+//   Traces ignored by rule resource:GET /ping: 4200
+//   WARNING: Resources of service web collapsed into resource:_other (last flush): 1500
 //   WARNING: Pre-sampling traces: 26.0 %
 //   WARNING: Pre-sampler: raising pre-sampling rate from 2.9 % to 5.0 %
+//   Traces with sampling priority 0: 12.0/s, kept 0.0/s
+//   Traces with sampling priority 1: 3.0/s, kept 3.0/s
+//
+//   Lang         Lang version   Tracer           Traces    Spans  Dropped   Errors
+//   go           go1.9.2        0.5.1               200      300        0        0
+//   python       2.7.14         0.10.0               40       60        5        1
 //
 //   Bytes sent (1 min): 3245
 //   Traces sent (1 min): 6
@@ -289,6 +395,9 @@ func getProgramBanner(version string) (string, string) {
 // -----8<-------------------------------------------------------
 //
 // The "WARNING:" lines are hidden if there's nothing dropped or no errors.
+// The sampling priority lines are only shown for priorities set by clients.
+// The table of client libraries, identified by the Datadog-Meta-* headers
+// they send, is only shown if some sent data.
 //
 // Typical output of 'trace-agent -info' when agent is not running:
 //
@@ -321,7 +430,18 @@ func Info(w io.Writer, conf *config.AgentConfig) error {
 		host = "127.0.0.1" // [FIXME:christian] not fool-proof
 	}
 	url := "http://localhost:" + strconv.Itoa(conf.ReceiverPort) + "/debug/vars"
+	address := "port " + strconv.Itoa(conf.ReceiverPort)
 	client := http.Client{Timeout: 3 * time.Second}
+	if conf.ReceiverPort == 0 && conf.ReceiverSocket != "" {
+		// not listening over TCP, only on the socket
+		url = "http://unix/debug/vars"
+		address = "socket " + conf.ReceiverSocket
+		client.Transport = &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", conf.ReceiverSocket)
+			},
+		}
+	}
 	resp, err := client.Get(url)
 	if err != nil {
 		// OK, here, we can't even make an http call on the agent port,
@@ -330,13 +450,13 @@ func Info(w io.Writer, conf *config.AgentConfig) error {
 		// debug further, this is where the expvar JSON should come from.
 		program, banner := getProgramBanner(Version)
 		_ = infoNotRunningTmpl.Execute(w, struct {
-			Banner       string
-			Program      string
-			ReceiverPort int
+			Banner  string
+			Program string
+			Address string
 		}{
-			Banner:       banner,
-			Program:      program,
-			ReceiverPort: conf.ReceiverPort,
+			Banner:  banner,
+			Program: program,
+			Address: address,
 		})
 		return err
 	}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"expvar"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -69,6 +73,7 @@ func (h *testServerWarningHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 "pid": 38149,
 "receiver": {"TracesBytes":10000,"ServicesBytes":1000,"SpansReceived":360,"TracesReceived":240,"SpansDropped":10,"TracesDropped":5},
 "presampler": {"Rate":0.421,"Error":"raising pre-sampling rate from 3.1 % to 5.0 %"},
+"sampler": {"Stats":{"KeptTPS":3.5,"TotalTPS":15.5,"PriorityTPS":{"0":12,"1":3},"PriorityKeptTPS":{"1":3}}},
 "uptime": 15,
 "version": {"BuildDate": "2017-02-01T14:28:10+0100", "GitBranch": "ufoot/statusinfo", "GitCommit": "396a217", "GoVersion": "go version go1.7 darwin/amd64", "Version": "0.99.0"}
 }`))
@@ -205,7 +210,7 @@ func TestWarning(t *testing.T) {
 	t.Logf("Info:\n%s\n", info)
 
 	lines := strings.Split(info, "\n")
-	assert.Equal(27, len(lines))
+	assert.Equal(29, len(lines))
 	assert.Regexp(regexp.MustCompile(`^={10,100}$`), lines[0])
 	assert.Regexp(regexp.MustCompile(`^Trace Agent \(v.*\)$`), lines[1])
 	assert.Regexp(regexp.MustCompile(`^={10,100}$`), lines[2])
@@ -227,14 +232,16 @@ func TestWarning(t *testing.T) {
 	assert.Equal("  WARNING: Spans dropped (1 min): 10", lines[16])
 	assert.Equal("  WARNING: Pre-sampling traces: 42.1 %", lines[17])
 	assert.Equal("  WARNING: Pre-sampler: raising pre-sampling rate from 3.1 % to 5.0 %", lines[18])
-	assert.Equal("", lines[19])
-	assert.Equal("  Bytes sent (1 min): 3591", lines[20])
-	assert.Equal("  Traces sent (1 min): 6", lines[21])
-	assert.Equal("  Stats sent (1 min): 60", lines[22])
-	assert.Equal("  WARNING: Traces API errors (1 min): 3/4", lines[23])
-	assert.Equal("  WARNING: Services API errors (1 min): 1/2", lines[24])
-	assert.Equal("", lines[25])
-	assert.Equal("", lines[26])
+	assert.Equal("  Traces with sampling priority 0: 12.0/s, kept 0.0/s", lines[19])
+	assert.Equal("  Traces with sampling priority 1: 3.0/s, kept 3.0/s", lines[20])
+	assert.Equal("", lines[21])
+	assert.Equal("  Bytes sent (1 min): 3591", lines[22])
+	assert.Equal("  Traces sent (1 min): 6", lines[23])
+	assert.Equal("  Stats sent (1 min): 60", lines[24])
+	assert.Equal("  WARNING: Traces API errors (1 min): 3/4", lines[25])
+	assert.Equal("  WARNING: Services API errors (1 min): 1/2", lines[26])
+	assert.Equal("", lines[27])
+	assert.Equal("", lines[28])
 }
 
 func TestNotRunning(t *testing.T) {
@@ -277,6 +284,40 @@ func TestNotRunning(t *testing.T) {
 	assert.Equal("", lines[6])
 }
 
+func TestInfoUnixSocket(t *testing.T) {
+	assert := assert.New(t)
+	conf := testInit(t)
+	assert.NotNil(conf)
+
+	dir, err := ioutil.TempDir("", "trace-agent-info")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// not listening over TCP, only on the socket
+	conf.ReceiverPort = 0
+	conf.ReceiverSocket = filepath.Join(dir, "apm.socket")
+
+	var buf bytes.Buffer
+	err = Info(&buf, conf)
+	assert.NotNil(err)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal("  Not running (socket "+conf.ReceiverSocket+")", lines[4])
+
+	listener, err := net.Listen("unix", conf.ReceiverSocket)
+	assert.Nil(err)
+	server := httptest.NewUnstartedServer(&testServerHandler{t: t})
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	buf.Reset()
+	err = Info(&buf, conf)
+	assert.Nil(err)
+	lines = strings.Split(buf.String(), "\n")
+	assert.Equal(21, len(lines))
+	assert.Equal("  Pid: 38149", lines[4])
+}
+
 func TestError(t *testing.T) {
 	assert := assert.New(t)
 	conf := testInit(t)
@@ -391,3 +432,42 @@ func TestInfoConfig(t *testing.T) {
 	conf.APIKey = ""              // patch upstream source so that we can use equality testing
 	assert.Equal(*conf, confCopy) // ensure all fields have been exported then parsed correctly
 }
+
+func TestInfoReceiverTags(t *testing.T) {
+	assert := assert.New(t)
+	conf := testInit(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+"config": {"HostName":"localhost.localdomain","ReceiverHost":"localhost","ReceiverPort":8126},
+"receiver": {"SpansReceived":360,"TracesReceived":240,"SpansDropped":10,"TracesDropped":5},
+"receiver_tags": [
+	{"Lang":"go","LangVersion":"go1.9.2","TracerVersion":"0.5.1","TracesReceived":200,"SpansReceived":300},
+	{"TracesReceived":40,"SpansReceived":60,"TracesDropped":5,"SpansDropped":10,"PayloadsDecodingError":1}
+],
+"presampler": {"Rate":1.0},
+"version": {"Version": "0.99.0"}
+}`))
+	}))
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	assert.Nil(err)
+	conf.ReceiverPort, err = strconv.Atoi(strings.Split(url.Host, ":")[1])
+	assert.Nil(err)
+
+	var buf bytes.Buffer
+	assert.Nil(Info(&buf, conf))
+	info := buf.String()
+	t.Logf("Info:\n%s\n", info)
+
+	assert.Contains(info, `  WARNING: Spans dropped (1 min): 10
+
+  Lang         Lang version   Tracer           Traces    Spans  Dropped   Errors
+  go           go1.9.2        0.5.1               200      300        0        0
+  unknown      -              -                    40       60        5        1
+
+  Bytes sent (1 min): 0
+`)
+}
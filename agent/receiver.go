@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	log "github.com/cihub/seelog"
-	"github.com/tinylib/msgp/msgp"
 
 	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
@@ -24,6 +26,16 @@ const (
 	maxRequestBodyLength = 10 * 1024 * 1024
 	tagTraceHandler      = "handler:traces"
 	tagServiceHandler    = "handler:services"
+	tagZipkinHandler     = "handler:zipkin"
+	tagJaegerHandler     = "handler:jaeger"
+
+	// RejectionDetailsHeader is the header clients set to "true" to get the
+	// list of the traces we rejected, and why, in the response
+	RejectionDetailsHeader = "X-Datadog-Rejection-Details"
+
+	// receiverRetryAfter is how long we ask clients to wait before sending
+	// again, when we are saturated
+	receiverRetryAfter = 2 * time.Second
 )
 
 // APIVersion is a dumb way to version our collector handlers
@@ -42,33 +54,50 @@ const (
 	// Traces: msgpack/JSON (Content-Type) slice of traces
 	// Services: msgpack/JSON, map[string]map[string][string]
 	v03 APIVersion = "v0.3"
+	// v04
+	// Traces: msgpack/JSON (Content-Type) slice of traces + returns service sampling ratios
+	// Services: msgpack/JSON, map[string]map[string][string]
+	v04 APIVersion = "v0.4"
 )
 
+// receivedTrace is a trace queued for processing, along with the client library
+// which sent it, so that it can still be accounted to it if dropped later on
+type receivedTrace struct {
+	trace model.Trace
+	tags  receiverTags
+}
+
 // HTTPReceiver is a collector that uses HTTP protocol and just holds
 // a chan where the spans received are sent one by one
 type HTTPReceiver struct {
-	traces   chan model.Trace
+	traces   chan receivedTrace
 	services chan model.ServicesMetadata
 	conf     *config.AgentConfig
+	dynConf  *config.DynamicConfig
 
-	stats      receiverStats
-	preSampler *sampler.PreSampler
+	stats         *receiverTagStats
+	preSampler    *sampler.PreSampler
+	clientLimiter *clientLimiter
 
-	exit chan struct{}
+	exit    chan struct{}
+	servers []*http.Server // one per listener
 
 	maxRequestBodyLength int64
 	debug                bool
 }
 
 // NewHTTPReceiver returns a pointer to a new HTTPReceiver
-func NewHTTPReceiver(conf *config.AgentConfig) *HTTPReceiver {
+func NewHTTPReceiver(conf *config.AgentConfig, dynConf *config.DynamicConfig) *HTTPReceiver {
 	// use buffered channels so that handlers are not waiting on downstream processing
 	return &HTTPReceiver{
-		traces:     make(chan model.Trace, 5000), // about 1000 traces/sec for 5 sec
-		services:   make(chan model.ServicesMetadata, 50),
-		conf:       conf,
-		preSampler: sampler.NewPreSampler(conf.PreSampleRate),
-		exit:       make(chan struct{}),
+		traces:        make(chan receivedTrace, 5000), // about 1000 traces/sec for 5 sec
+		services:      make(chan model.ServicesMetadata, 50),
+		conf:          conf,
+		dynConf:       dynConf,
+		stats:         newReceiverTagStats(),
+		preSampler:    sampler.NewPreSampler(conf.PreSampleRate),
+		clientLimiter: newClientLimiter(conf),
+		exit:          make(chan struct{}),
 
 		maxRequestBodyLength: maxRequestBodyLength,
 		debug:                strings.ToLower(conf.LogLevel) == "debug",
@@ -85,15 +114,40 @@ func (r *HTTPReceiver) Run() {
 	http.HandleFunc("/v0.2/traces", r.httpHandleWithVersion(v02, r.handleTraces))
 	http.HandleFunc("/v0.2/services", r.httpHandleWithVersion(v02, r.handleServices))
 
-	// current collector API
 	http.HandleFunc("/v0.3/traces", r.httpHandleWithVersion(v03, r.handleTraces))
 	http.HandleFunc("/v0.3/services", r.httpHandleWithVersion(v03, r.handleServices))
 
-	// expvar implicitely publishes "/debug/vars" on the same port
+	// current collector API
+	http.HandleFunc("/v0.4/traces", r.httpHandleWithVersion(v04, r.handleTraces))
+	http.HandleFunc("/v0.4/services", r.httpHandleWithVersion(v04, r.handleServices))
+
+	// Zipkin compatible API, so that Zipkin tracers can report to us
+	http.HandleFunc("/api/v2/spans", r.httpHandle(r.handleZipkinSpans))
+	// Jaeger collector compatible API, as used by jaeger-client HTTPSender
+	http.HandleFunc("/api/traces", r.httpHandle(r.handleJaegerBatch))
+
+	// expvar implicitely publishes "/debug/vars" on the same port, and on the socket
+
+	if r.conf.ReceiverPort > 0 {
+		addr := fmt.Sprintf("%s:%d", r.conf.ReceiverHost, r.conf.ReceiverPort)
+		if err := r.Listen(addr, ""); err != nil {
+			die("%v", err)
+		}
+	} else if r.conf.ReceiverSocket == "" {
+		die("cannot receive traces: no receiver port nor socket")
+	}
+
+	if r.conf.ReceiverTLSPort > 0 {
+		tlsAddr := fmt.Sprintf("%s:%d", r.conf.ReceiverHost, r.conf.ReceiverTLSPort)
+		if err := r.ListenTLS(tlsAddr); err != nil {
+			die("%v", err)
+		}
+	}
 
-	addr := fmt.Sprintf("%s:%d", r.conf.ReceiverHost, r.conf.ReceiverPort)
-	if err := r.Listen(addr, ""); err != nil {
-		die("%v", err)
+	if r.conf.ReceiverSocket != "" {
+		if err := r.ListenUnix(r.conf.ReceiverSocket, r.conf.ReceiverSocketPerm); err != nil {
+			die("%v", err)
+		}
 	}
 
 	go func() {
@@ -113,23 +167,92 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 		return fmt.Errorf("cannot listen on %s: %v", addr, err)
 	}
 
+	if err := r.serve(listener, nil); err != nil {
+		return err
+	}
+
+	log.Infof("listening for traces at http://%s%s", addr, logExtra)
+	return nil
+}
+
+// ListenTLS creates a new HTTPS server listening on the provided address.
+// Its certificates are reloaded from disk when they change.
+func (r *HTTPReceiver) ListenTLS(addr string) error {
+	reloader, err := newTLSReloader(r.conf)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", addr, err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", addr, err)
+	}
+
+	if err := r.serve(listener, reloader.serverConfig()); err != nil {
+		listener.Close()
+		return err
+	}
+
+	log.Infof("listening for traces at https://%s", addr)
+	return nil
+}
+
+// ListenUnix creates a new HTTP server listening on a Unix domain socket
+// created at the provided path with the given permissions. The server is
+// the same as the TCP one: same handlers, same connection limit.
+func (r *HTTPReceiver) ListenUnix(path string, perm os.FileMode) error {
+	// a socket left over by a previous run would prevent us from binding
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("cannot remove stale socket %s: %v", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", path, err)
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		listener.Close()
+		return fmt.Errorf("cannot set permissions of %s: %v", path, err)
+	}
+
+	if err := r.serve(listener, nil); err != nil {
+		listener.Close()
+		return err
+	}
+
+	log.Infof("listening for traces at unix://%s", path)
+	return nil
+}
+
+// serve starts serving our HTTP API on the given listener, until the receiver
+// exits. Connections are encrypted if tlsConfig is not nil.
+func (r *HTTPReceiver) serve(listener net.Listener, tlsConfig *tls.Config) error {
 	stoppableListener, err := NewStoppableListener(listener, r.exit,
 		r.conf.ConnectionLimit)
 	if err != nil {
 		return fmt.Errorf("cannot create stoppable listener: %v", err)
 	}
+	var serverListener net.Listener = stoppableListener
+	if tlsConfig != nil {
+		serverListener = tls.NewListener(stoppableListener, tlsConfig)
+	}
 
 	timeout := 5 * time.Second
 	if r.conf.ReceiverTimeout > 0 {
 		timeout = time.Duration(r.conf.ReceiverTimeout) * time.Second
 	}
 
-	server := http.Server{
+	server := &http.Server{
 		ReadTimeout:  time.Second * time.Duration(timeout),
 		WriteTimeout: time.Second * time.Duration(timeout),
 	}
-
-	log.Infof("listening for traces at http://%s%s", addr, logExtra)
+	r.servers = append(r.servers, server)
 
 	go func() {
 		defer watchdog.LogOnPanic()
@@ -137,15 +260,66 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 	}()
 	go func() {
 		defer watchdog.LogOnPanic()
-		server.Serve(stoppableListener)
+		server.Serve(serverListener)
 	}()
 
 	return nil
 }
 
+// Stop stops accepting connections, then waits for the requests being handled
+// to complete, until ctx is done. Once it returns without error, no more
+// traces are queued.
+func (r *HTTPReceiver) Stop(ctx context.Context) error {
+	close(r.exit)
+
+	var err error
+	for _, server := range r.servers {
+		if e := server.Shutdown(ctx); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// requestBody is the body of the requests we handle. Its data is transparently
+// decompressed and limited in size, while still keeping track of the size of
+// the payload sent over the wire.
+type requestBody struct {
+	*model.LimitedReader // decompressed data
+	raw                  *model.LimitedReader
+}
+
+// RawCount returns the number of bytes read from the wire, before decompression
+func (b *requestBody) RawCount() int64 {
+	return b.raw.Count
+}
+
+// Close closes both the decompressing and the underlying readers
+func (b *requestBody) Close() error {
+	b.LimitedReader.Close()
+	return b.raw.Close()
+}
+
 func (r *HTTPReceiver) httpHandle(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		req.Body = model.NewLimitedReader(req.Body, r.maxRequestBodyLength)
+		raw := model.NewLimitedReader(req.Body, r.maxRequestBodyLength)
+
+		// the limit also applies to the decompressed data, to protect us from zip bombs
+		body, err := model.NewCompressedReader(raw, req.Header.Get("Content-Encoding"))
+		if err != nil {
+			raw.Close()
+			log.Errorf("rejecting client request: %v", err)
+			if _, ok := err.(*model.UnsupportedEncodingError); ok {
+				HTTPFormatError(nil, w)
+			} else {
+				HTTPDecodingError(err, nil, w)
+			}
+			return
+		}
+		req.Body = &requestBody{
+			LimitedReader: model.NewLimitedReader(body, r.maxRequestBodyLength),
+			raw:           raw,
+		}
 		defer req.Body.Close()
 
 		fn(w, req)
@@ -155,7 +329,7 @@ func (r *HTTPReceiver) httpHandle(fn http.HandlerFunc) http.HandlerFunc {
 func (r *HTTPReceiver) httpHandleWithVersion(v APIVersion, f func(APIVersion, http.ResponseWriter, *http.Request)) http.HandlerFunc {
 	return r.httpHandle(func(w http.ResponseWriter, req *http.Request) {
 		contentType := req.Header.Get("Content-Type")
-		if contentType == "application/msgpack" && (v == v01 || v == v02) {
+		if getMediaType(contentType) == "application/msgpack" && (v == v01 || v == v02) {
 			// msgpack is only supported for versions 0.3
 			log.Errorf("rejecting client request, unsupported media type %q", contentType)
 			HTTPFormatError([]string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
@@ -166,24 +340,95 @@ func (r *HTTPReceiver) httpHandleWithVersion(v APIVersion, f func(APIVersion, ht
 	})
 }
 
+// countBytes accounts for the bytes read from the body of a request, as
+// received and once decompressed, on the traces or the services endpoint
+func (r *HTTPReceiver) countBytes(req *http.Request, ts *receiverStats, traces bool) {
+	body := req.Body.(*requestBody)
+	raw, uncompressed := &ts.ServicesBytes, &ts.ServicesBytesUncompressed
+	if traces {
+		raw, uncompressed = &ts.TracesBytes, &ts.TracesBytesUncompressed
+	}
+	if bytesRead := body.RawCount(); bytesRead > 0 {
+		atomic.AddInt64(raw, bytesRead)
+	}
+	if bytesRead := body.Count; bytesRead > 0 {
+		atomic.AddInt64(uncompressed, bytesRead)
+	}
+}
+
+// replyTraces acknowledges a traces payload, the way the given API version expects it
+func (r *HTTPReceiver) replyTraces(v APIVersion, w http.ResponseWriter) {
+	switch v {
+	case v04:
+		// return the sample rate we currently apply to each service as JSON
+		HTTPRateByService(w, r.dynConf)
+	default:
+		// simple response, acknowledge with "OK"
+		HTTPOK(w)
+	}
+}
+
+// replyDecodingError rejects a payload which could not be decoded, telling
+// apart the media types we have no decoder for from malformed payloads.
+func (r *HTTPReceiver) replyDecodingError(err error, ts *receiverStats, tags []string, w http.ResponseWriter) {
+	atomic.AddInt64(&ts.PayloadsDecodingError, 1)
+	if e, ok := err.(*UnsupportedMediaTypeError); ok {
+		HTTPUnsupportedMediaType(e.MediaType, tags, w)
+		return
+	}
+	HTTPDecodingError(err, tags, w)
+}
+
+// saturated tells if we are unable to take traceCount more traces for now,
+// because our downstream consumer is lagging behind. Then, the client should
+// rather keep its traces and retry later than have us drop them.
+func (r *HTTPReceiver) saturated(traceCount int) bool {
+	queued := len(r.traces)
+	if traceCount > cap(r.traces)/2 {
+		// a payload this big might never fit in the queue, and the client
+		// would retry it forever: only push it back when the queue has a
+		// backlog, the traces it cannot take being dropped otherwise
+		traceCount = cap(r.traces) / 2
+	}
+	if queued+traceCount > cap(r.traces) {
+		return true
+	}
+	// when the pre-sampler is active we are already short on CPU, push back
+	// earlier so that the queue does not fill up
+	return r.preSampler.Rate() < 1 && queued > cap(r.traces)/2
+}
+
+// replyTooManyRequests rejects a payload because we are saturated, telling
+// the client to retry later.
+func (r *HTTPReceiver) replyTooManyRequests(traceCount int, ts *receiverStats, tags []string, w http.ResponseWriter) {
+	atomic.AddInt64(&ts.PayloadsRateLimited, 1)
+	atomic.AddInt64(&ts.TracesRateLimited, int64(traceCount))
+
+	log.Errorf("rejecting payload of %d traces, the agent is saturated", traceCount)
+	HTTPTooManyRequests(receiverRetryAfter, tags, w)
+}
+
 // handleTraces knows how to handle a bunch of traces
 func (r *HTTPReceiver) handleTraces(v APIVersion, w http.ResponseWriter, req *http.Request) {
 	if !r.preSampler.Sample(req) {
-		HTTPOK(w)
+		r.replyTraces(v, w)
 		return
 	}
 
 	var traces model.Traces
 	contentType := req.Header.Get("Content-Type")
+	rtags := receiverTagsFromRequest(req)
+	ts := r.stats.get(rtags)
+	tags := append([]string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, rtags.toStatsd()...)
 
 	switch v {
 	case v01:
 		// We cannot use decodeReceiverPayload because []model.Span does not
 		// implement msgp.Decodable. This hack can be removed once we
 		// drop v01 support.
-		if contentType != "application/json" && contentType != "text/json" && contentType != "" {
+		if mt := getMediaType(contentType); mt != "application/json" && mt != "text/json" && mt != "" {
 			log.Errorf("rejecting client request, unsupported media type %q", contentType)
-			HTTPFormatError([]string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
+			HTTPFormatError(tags, w)
 			return
 		}
 
@@ -191,7 +436,7 @@ func (r *HTTPReceiver) handleTraces(v APIVersion, w http.ResponseWriter, req *ht
 		var spans []model.Span
 		if err := json.NewDecoder(req.Body).Decode(&spans); err != nil {
 			log.Errorf("cannot decode %s traces payload: %v", v, err)
-			HTTPDecodingError(err, []string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
+			r.replyDecodingError(err, ts, tags, w)
 			return
 		}
 		traces = model.TracesFromSpans(spans)
@@ -199,77 +444,285 @@ func (r *HTTPReceiver) handleTraces(v APIVersion, w http.ResponseWriter, req *ht
 	case v02:
 		fallthrough
 	case v03:
-		if err := decodeReceiverPayload(req.Body, &traces, v, contentType); err != nil {
+		fallthrough
+	case v04:
+		if newDecoder, ok := getTracesStreamDecoder(contentType); ok {
+			r.handleTracesStream(v, newDecoder, ts, rtags, tags, w, req)
+			return
+		}
+		if err := decodeReceiverPayload(req.Body, &traces, contentType); err != nil {
 			log.Errorf("cannot decode %s traces payload: %v", v, err)
-			HTTPDecodingError(err, []string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
+			r.replyDecodingError(err, ts, tags, w)
 			return
 		}
 
 	default:
-		HTTPEndpointNotSupported([]string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
+		HTTPEndpointNotSupported(tags, w)
 		return
 	}
 
-	HTTPOK(w)
+	if r.saturated(len(traces)) {
+		r.replyTooManyRequests(len(traces), ts, tags, w)
+		return
+	}
+
+	r.tagClientCommonName(traces, req)
 
-	bytesRead := req.Body.(*model.LimitedReader).Count
-	if bytesRead > 0 {
-		atomic.AddInt64(&r.stats.TracesBytes, int64(bytesRead))
+	r.countBytes(req, ts, true)
+
+	if req.Header.Get(RejectionDetailsHeader) != "true" {
+		r.replyTraces(v, w)
+		r.receiveTraces(traces, ts, rtags, r.clientID(req))
+		return
 	}
 
-	// normalize data
-	for i := range traces {
-		spans := len(traces[i])
-		normTrace, err := model.NormalizeTrace(traces[i])
-		if err != nil {
-			atomic.AddInt64(&r.stats.TracesDropped, 1)
-			atomic.AddInt64(&r.stats.SpansDropped, int64(spans))
+	// the client wants to know which traces we rejected and why, so we
+	// have to process them before replying
+	rejected := r.receiveTraces(traces, ts, rtags, r.clientID(req))
+	var rates map[string]float64
+	if v == v04 {
+		rates = r.dynConf.RateByService.GetAll()
+	}
+	HTTPRejections(w, rates, rejected)
+}
 
-			errorMsg := fmt.Sprintf("dropping trace reason: %s (debug for more info), %v", err, normTrace)
-			if len(errorMsg) > 150 && r.debug {
-				errorMsg = errorMsg[:150] + "..."
-			}
-			log.Errorf(errorMsg)
-		} else {
-			atomic.AddInt64(&r.stats.SpansDropped, int64(spans-len(normTrace)))
-
-			// if our downstream consumer is slow, we drop the trace on the floor
-			// this is a safety net against us using too much memory
-			// when clients flood us
-			select {
-			case r.traces <- normTrace:
-			default:
-				atomic.AddInt64(&r.stats.TracesDropped, 1)
-				atomic.AddInt64(&r.stats.SpansDropped, int64(spans))
-
-				log.Errorf("dropping trace reason: rate-limited")
+// handleTracesStream handles a payload of traces with a stream decoder,
+// decoding and queueing its traces one at a time instead of decoding the
+// whole payload first. It stops at the first malformed trace, the traces
+// before it being processed anyway.
+func (r *HTTPReceiver) handleTracesStream(v APIVersion, newDecoder TracesStreamDecoder, ts *receiverStats, rtags receiverTags, tags []string, w http.ResponseWriter, req *http.Request) {
+	dec, err := newDecoder(req.Body)
+	if err != nil {
+		log.Errorf("cannot decode %s traces payload: %v", v, err)
+		r.replyDecodingError(err, ts, tags, w)
+		return
+	}
+
+	if r.saturated(dec.Len()) {
+		r.replyTooManyRequests(dec.Len(), ts, tags, w)
+		return
+	}
+
+	var rejected []traceRejection
+	details := req.Header.Get(RejectionDetailsHeader) == "true"
+	client := r.clientID(req)
+	now := time.Now()
+	total := dec.Len()
+	for i := 0; ; i++ {
+		trace, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the traces before it are queued already: the payload is still
+			// acknowledged, else clients retrying it would send them again
+			log.Errorf("cannot decode %s traces payload, dropping the %d traces from trace %d on: %v", v, total-i, i, err)
+			atomic.AddInt64(&ts.PayloadsDecodingError, 1)
+			atomic.AddInt64(&ts.TracesReceived, int64(total-i))
+			atomic.AddInt64(&ts.TracesDropped, int64(total-i))
+			for j := i; details && j < total; j++ {
+				rejected = append(rejected, newTraceRejection(j, nil, "decoding error: "+err.Error()))
 			}
+			break
+		}
+
+		r.tagClientCommonName(model.Traces{trace}, req)
+		if rejection := r.receiveTrace(i, trace, ts, rtags, client, now); rejection != nil {
+			rejected = append(rejected, *rejection)
+		}
+	}
+
+	r.countBytes(req, ts, true)
+
+	if !details {
+		r.replyTraces(v, w)
+		return
+	}
+	var rates map[string]float64
+	if v == v04 {
+		rates = r.dynConf.RateByService.GetAll()
+	}
+	HTTPRejections(w, rates, rejected)
+}
+
+// receiveTraces normalizes traces decoded from a client request and queues
+// them for processing, dropping the invalid ones and the ones over the limit
+// of the client. It returns why each dropped trace was dropped.
+func (r *HTTPReceiver) receiveTraces(traces model.Traces, ts *receiverStats, rtags receiverTags, client string) []traceRejection {
+	var rejected []traceRejection
+	now := time.Now()
+
+	for i := range traces {
+		if rejection := r.receiveTrace(i, traces[i], ts, rtags, client, now); rejection != nil {
+			rejected = append(rejected, *rejection)
 		}
+	}
+
+	return rejected
+}
+
+// receiveTrace normalizes the trace at index i of a client payload and queues
+// it for processing, accounting for it in ts, the stats of rtags. If it drops
+// the trace, it returns why.
+func (r *HTTPReceiver) receiveTrace(i int, trace model.Trace, ts *receiverStats, rtags receiverTags, client string, now time.Time) *traceRejection {
+	spans := len(trace)
+
+	atomic.AddInt64(&ts.TracesReceived, 1)
+	atomic.AddInt64(&ts.SpansReceived, int64(spans))
+
+	if !r.clientLimiter.allow(client, spans, now) {
+		// logged per client in logStats, not to flood the logs
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		rejection := newTraceRejection(i, trace, "client-rate-limited")
+		return &rejection
+	}
+
+	// normalize data
+	normTrace, err := model.NormalizeTrace(trace)
+	if err != nil {
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		rejection := newTraceRejection(i, trace, err.Error())
+
+		errorMsg := fmt.Sprintf("dropping trace reason: %s (debug for more info), %v", err, normTrace)
+		if len(errorMsg) > 150 && r.debug {
+			errorMsg = errorMsg[:150] + "..."
+		}
+		log.Errorf(errorMsg)
+		return &rejection
+	}
+
+	atomic.AddInt64(&ts.SpansDropped, int64(spans-len(normTrace)))
 
-		atomic.AddInt64(&r.stats.TracesReceived, 1)
-		atomic.AddInt64(&r.stats.SpansReceived, int64(spans))
+	// if our downstream consumer is slow, we drop the trace on the floor
+	// this is a safety net against us using too much memory
+	// when clients flood us
+	select {
+	case r.traces <- receivedTrace{trace: normTrace, tags: rtags}:
+	default:
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		rejection := newTraceRejection(i, normTrace, "rate-limited")
+
+		log.Errorf("dropping trace reason: rate-limited")
+		return &rejection
 	}
+
+	return nil
+}
+
+// handleZipkinSpans handles a Zipkin v2 JSON payload, a list of spans
+// belonging to any number of traces.
+func (r *HTTPReceiver) handleZipkinSpans(w http.ResponseWriter, req *http.Request) {
+	if !r.preSampler.Sample(req) {
+		HTTPAccepted(w)
+		return
+	}
+
+	rtags := receiverTagsFromRequest(req)
+	ts := r.stats.get(rtags)
+	tags := append([]string{tagZipkinHandler}, rtags.toStatsd()...)
+
+	if mt := getMediaType(req.Header.Get("Content-Type")); mt != "application/json" && mt != "" {
+		log.Errorf("rejecting zipkin request, unsupported media type %q", mt)
+		HTTPUnsupportedMediaType(mt, tags, w)
+		return
+	}
+
+	var zspans []model.ZipkinSpan
+	if err := json.NewDecoder(req.Body).Decode(&zspans); err != nil {
+		log.Errorf("cannot decode zipkin spans payload: %v", err)
+		r.replyDecodingError(err, ts, tags, w)
+		return
+	}
+	traces, err := model.TracesFromZipkinSpans(zspans)
+	if err != nil {
+		log.Errorf("cannot convert zipkin spans: %v", err)
+		r.replyDecodingError(err, ts, tags, w)
+		return
+	}
+
+	if r.saturated(len(traces)) {
+		r.replyTooManyRequests(len(traces), ts, tags, w)
+		return
+	}
+
+	HTTPAccepted(w)
+
+	r.tagClientCommonName(traces, req)
+
+	r.countBytes(req, ts, true)
+
+	r.receiveTraces(traces, ts, rtags, r.clientID(req))
+}
+
+// handleJaegerBatch handles a Jaeger batch of spans, encoded with the Thrift
+// binary protocol.
+func (r *HTTPReceiver) handleJaegerBatch(w http.ResponseWriter, req *http.Request) {
+	if !r.preSampler.Sample(req) {
+		HTTPAccepted(w)
+		return
+	}
+
+	rtags := receiverTagsFromRequest(req)
+	ts := r.stats.get(rtags)
+	tags := append([]string{tagJaegerHandler}, rtags.toStatsd()...)
+
+	if format := req.URL.Query().Get("format"); format != "" && format != "jaeger.thrift" {
+		log.Errorf("rejecting jaeger request, unsupported format %q", format)
+		HTTPFormatError(tags, w)
+		return
+	}
+	switch mt := getMediaType(req.Header.Get("Content-Type")); mt {
+	case "application/x-thrift", "application/vnd.apache.thrift.binary":
+	default:
+		log.Errorf("rejecting jaeger request, unsupported media type %q", mt)
+		HTTPUnsupportedMediaType(mt, tags, w)
+		return
+	}
+
+	batch, err := model.DecodeJaegerBatch(req.Body)
+	if err != nil {
+		log.Errorf("cannot decode jaeger batch: %v", err)
+		r.replyDecodingError(err, ts, tags, w)
+		return
+	}
+	traces := model.TracesFromJaegerBatch(batch)
+
+	if r.saturated(len(traces)) {
+		r.replyTooManyRequests(len(traces), ts, tags, w)
+		return
+	}
+
+	HTTPAccepted(w)
+
+	r.tagClientCommonName(traces, req)
+
+	r.countBytes(req, ts, true)
+
+	r.receiveTraces(traces, ts, rtags, r.clientID(req))
 }
 
 // handleServices handle a request with a list of several services
 func (r *HTTPReceiver) handleServices(v APIVersion, w http.ResponseWriter, req *http.Request) {
 
 	var servicesMeta model.ServicesMetadata
+	rtags := receiverTagsFromRequest(req)
+	ts := r.stats.get(rtags)
 
 	contentType := req.Header.Get("Content-Type")
-	if err := decodeReceiverPayload(req.Body, &servicesMeta, v, contentType); err != nil {
+	if err := decodeReceiverPayload(req.Body, &servicesMeta, contentType); err != nil {
 		log.Errorf("cannot decode %s services payload: %v", v, err)
-		HTTPDecodingError(err, []string{tagServiceHandler, fmt.Sprintf("v:%s", v)}, w)
+		tags := append([]string{tagServiceHandler, fmt.Sprintf("v:%s", v)}, rtags.toStatsd()...)
+		r.replyDecodingError(err, ts, tags, w)
 		return
 	}
 
 	statsd.Client.Count("datadog.trace_agent.receiver.service", int64(len(servicesMeta)), nil, 1)
 	HTTPOK(w)
 
-	bytesRead := req.Body.(*model.LimitedReader).Count
-	if bytesRead > 0 {
-		atomic.AddInt64(&r.stats.TracesBytes, int64(bytesRead))
-	}
+	r.countBytes(req, ts, false)
 
 	r.services <- servicesMeta
 }
@@ -277,78 +730,72 @@ func (r *HTTPReceiver) handleServices(v APIVersion, w http.ResponseWriter, req *
 // logStats periodically submits stats about the receiver to statsd
 func (r *HTTPReceiver) logStats() {
 	var accStats receiverStats
+	accTagStats := make(map[receiverTags]receiverStats)
+	accClientStats := make(map[string]clientLimitStats)
 	var lastLog time.Time
 
 	for now := range time.Tick(10 * time.Second) {
 		// Load counters and reset them for the next flush
-		tracesBytes := atomic.SwapInt64(&r.stats.TracesBytes, 0)
-		accStats.TracesBytes += tracesBytes
-
-		servicesBytes := atomic.SwapInt64(&r.stats.ServicesBytes, 0)
-		accStats.ServicesBytes += servicesBytes
-
-		spans := atomic.SwapInt64(&r.stats.SpansReceived, 0)
-		accStats.SpansReceived += spans
+		for rtags, rs := range r.stats.swap() {
+			accStats.add(rs)
+			acc := accTagStats[rtags]
+			acc.add(rs)
+			accTagStats[rtags] = acc
 
-		traces := atomic.SwapInt64(&r.stats.TracesReceived, 0)
-		accStats.TracesReceived += traces
+			submitReceiverStats(rs, rtags.toStatsd())
+		}
 
-		sdropped := atomic.SwapInt64(&r.stats.SpansDropped, 0)
-		accStats.SpansDropped += sdropped
+		for client, cs := range r.clientLimiter.swapStats(now) {
+			acc := accClientStats[client]
+			acc.TracesRateLimited += cs.TracesRateLimited
+			acc.SpansRateLimited += cs.SpansRateLimited
+			accClientStats[client] = acc
 
-		tdropped := atomic.SwapInt64(&r.stats.TracesDropped, 0)
-		accStats.TracesDropped += tdropped
+			tags := []string{"client:" + client}
+			statsd.Client.Count("datadog.trace_agent.receiver.client_trace_rate_limited", cs.TracesRateLimited, tags, 1)
+			statsd.Client.Count("datadog.trace_agent.receiver.client_span_rate_limited", cs.SpansRateLimited, tags, 1)
+		}
 
 		statsd.Client.Gauge("datadog.trace_agent.heartbeat", 1, []string{"version:" + Version}, 1)
 
-		statsd.Client.Count("datadog.trace_agent.receiver.traces", tracesBytes, []string{"endpoint:traces"}, 1)
-		statsd.Client.Count("datadog.trace_agent.receiver.services", servicesBytes, []string{"endpoint:services"}, 1)
-		statsd.Client.Count("datadog.trace_agent.receiver.span", spans, nil, 1)
-		statsd.Client.Count("datadog.trace_agent.receiver.trace", traces, nil, 1)
-		statsd.Client.Count("datadog.trace_agent.receiver.span_dropped", sdropped, nil, 1)
-		statsd.Client.Count("datadog.trace_agent.receiver.trace_dropped", tdropped, nil, 1)
-
 		if now.Sub(lastLog) >= time.Minute {
 			updateReceiverStats(accStats)
-			log.Infof("receiver handled %d spans, dropped %d ; handled %d traces, dropped %d",
+			updateReceiverTagStats(sortedTagStats(accTagStats))
+			updateClientLimitStats(accClientStats)
+			log.Infof("receiver handled %d spans, dropped %d ; handled %d traces, dropped %d ; asked clients to retry %d traces",
 				accStats.SpansReceived, accStats.SpansDropped,
-				accStats.TracesReceived, accStats.TracesDropped)
+				accStats.TracesReceived, accStats.TracesDropped,
+				accStats.TracesRateLimited)
+			for client, cs := range accClientStats {
+				log.Warnf("client %s went over its limit of %.0f spans/s, dropped %d traces, %d spans",
+					client, r.clientLimiter.rate, cs.TracesRateLimited, cs.SpansRateLimited)
+			}
 
 			accStats = receiverStats{}
+			accTagStats = make(map[receiverTags]receiverStats)
+			accClientStats = make(map[string]clientLimitStats)
 			lastLog = now
 		}
 	}
 }
 
-// receiverStats contains stats about the volume of data received
-type receiverStats struct {
-	// TracesBytes is the amount of data received on the traces endpoint (raw data, encoded, compressed).
-	TracesBytes int64
-	// ServicesBytes is the amount of data received on the services endpoint (raw data, encoded, compressed).
-	ServicesBytes int64
-	// SpansReceived is the number of spans received, including the dropped ones
-	SpansReceived int64
-	// TracesReceived is the number of traces received, including the dropped ones
-	TracesReceived int64
-	// SpansDropped is the number of spans dropped
-	SpansDropped int64
-	// SpansReceived is the number of traces dropped
-	TracesDropped int64
-}
-
-func decodeReceiverPayload(r io.Reader, dest msgp.Decodable, v APIVersion, contentType string) error {
-	switch contentType {
-	case "application/msgpack":
-		return msgp.Decode(r, dest)
-
-	case "application/json":
-		fallthrough
-	case "text/json":
-		fallthrough
-	case "":
-		return json.NewDecoder(r).Decode(dest)
-
-	default:
-		panic(fmt.Sprintf("unhandled content type %q", contentType))
+// submitReceiverStats submits the stats of a client library to statsd
+func submitReceiverStats(rs receiverStats, tags []string) {
+	// never append to tags in place, it is shared by all the metrics
+	tags = tags[:len(tags):len(tags)]
+	withTag := func(tag string) []string {
+		return append(tags, tag)
 	}
+
+	statsd.Client.Count("datadog.trace_agent.receiver.traces", rs.TracesBytes, withTag("endpoint:traces"), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.services", rs.ServicesBytes, withTag("endpoint:services"), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.traces_uncompressed", rs.TracesBytesUncompressed, withTag("endpoint:traces"), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.services_uncompressed", rs.ServicesBytesUncompressed, withTag("endpoint:services"), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.span", rs.SpansReceived, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.trace", rs.TracesReceived, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.span_dropped", rs.SpansDropped, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.trace_dropped", rs.TracesDropped, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.payload_rate_limited", rs.PayloadsRateLimited, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.trace_rate_limited", rs.TracesRateLimited, tags, 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.payload_decoding_error", rs.PayloadsDecodingError, tags, 1)
 }
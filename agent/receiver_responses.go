@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 )
@@ -16,6 +22,21 @@ func HTTPFormatError(tags []string, w http.ResponseWriter) {
 	http.Error(w, "format-error", http.StatusUnsupportedMediaType)
 }
 
+// HTTPUnsupportedMediaType is used for payloads sent with a media type we have no decoder for
+func HTTPUnsupportedMediaType(mediaType string, tags []string, w http.ResponseWriter) {
+	tags = append(tags, "error:unsupported-media-type")
+	statsd.Client.Count("datadog.trace_agent.receiver.error", 1, tags, 1)
+	http.Error(w, fmt.Sprintf("unsupported-media-type: %q", mediaType), http.StatusUnsupportedMediaType)
+}
+
+// HTTPTooManyRequests is used when we are saturated, the client should retry after the given delay
+func HTTPTooManyRequests(retryAfter time.Duration, tags []string, w http.ResponseWriter) {
+	tags = append(tags, "error:too-many-requests")
+	statsd.Client.Count("datadog.trace_agent.receiver.error", 1, tags, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+	http.Error(w, "too-many-requests", http.StatusTooManyRequests)
+}
+
 // HTTPDecodingError is used for errors happening in decoding
 func HTTPDecodingError(err error, tags []string, w http.ResponseWriter) {
 	status := http.StatusBadRequest
@@ -46,3 +67,75 @@ func HTTPOK(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 	io.WriteString(w, "OK\n")
 }
+
+// HTTPAccepted acknowledges a payload the way the Zipkin and Jaeger APIs do, with no content
+func HTTPAccepted(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// traceResponse is the body sent back to clients of the v0.4 traces endpoint
+type traceResponse struct {
+	// Rates is the sample rate the agent applies to each service, keyed by "service:<name>,env:<env>"
+	Rates map[string]float64 `json:"rate_by_service"`
+}
+
+// HTTPRateByService outputs, as JSON, the sample rates recommended for all services
+func HTTPRateByService(w http.ResponseWriter, dynConf *config.DynamicConfig) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	response := traceResponse{
+		Rates: dynConf.RateByService.GetAll(), // this is thread-safe
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		tags := []string{"error:response-error"}
+		statsd.Client.Count("datadog.trace_agent.receiver.error", 1, tags, 1)
+		log.Errorf("cannot encode rates by service: %v", err)
+	}
+}
+
+// traceRejection tells a client why one of the traces of its payload was rejected
+type traceRejection struct {
+	// Index is the position of the trace in the payload
+	Index       int    `json:"index"`
+	TraceID     uint64 `json:"trace_id"`
+	TraceIDHigh uint64 `json:"trace_id_high,omitempty"` // upper 64 bits of 128-bit trace IDs
+	Error       string `json:"error"`
+}
+
+func newTraceRejection(index int, trace model.Trace, reason string) traceRejection {
+	rejection := traceRejection{Index: index, Error: reason}
+	if len(trace) > 0 {
+		rejection.TraceID = trace[0].TraceID
+		rejection.TraceIDHigh = trace[0].TraceIDHigh
+	}
+	return rejection
+}
+
+// rejectionsResponse is the body sent back to the clients which asked for
+// the details of the traces we rejected
+type rejectionsResponse struct {
+	// only set for the API versions which return sample rates
+	*traceResponse
+	Rejected []traceRejection `json:"rejected"`
+}
+
+// HTTPRejections outputs, as JSON, the traces of a payload we rejected. The
+// sample rates are included if not nil.
+func HTTPRejections(w http.ResponseWriter, rates map[string]float64, rejected []traceRejection) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	response := rejectionsResponse{Rejected: rejected}
+	if rates != nil {
+		response.traceResponse = &traceResponse{Rates: rates}
+	}
+	if response.Rejected == nil {
+		response.Rejected = []traceRejection{}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		tags := []string{"error:response-error"}
+		statsd.Client.Count("datadog.trace_agent.receiver.error", 1, tags, 1)
+		log.Errorf("cannot encode rejected traces: %v", err)
+	}
+}
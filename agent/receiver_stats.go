@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// headers client libraries describe themselves with
+	headerLang          = "Datadog-Meta-Lang"
+	headerLangVersion   = "Datadog-Meta-Lang-Version"
+	headerTracerVersion = "Datadog-Meta-Tracer-Version"
+
+	// receiverMaxTagSets caps the number of client libraries we keep stats
+	// for, beyond which the stats of new ones are merged under tagsOther
+	receiverMaxTagSets = 100
+	// receiverMaxTagLength caps the length of the header values we keep
+	receiverMaxTagLength = 64
+	// receiverMaxIdleSwaps is the number of stats intervals in a row a client
+	// library must send nothing in before we forget about it
+	receiverMaxIdleSwaps = 2
+)
+
+// tagsOther identifies the client libraries we have no room to keep stats for
+var tagsOther = receiverTags{Lang: "_other"}
+
+// receiverTags identifies the client library which sent a payload. Its
+// fields are empty for clients which do not describe themselves.
+type receiverTags struct {
+	Lang          string
+	LangVersion   string
+	TracerVersion string
+}
+
+func receiverTagsFromRequest(req *http.Request) receiverTags {
+	return receiverTags{
+		Lang:          truncateTag(req.Header.Get(headerLang)),
+		LangVersion:   truncateTag(req.Header.Get(headerLangVersion)),
+		TracerVersion: truncateTag(req.Header.Get(headerTracerVersion)),
+	}
+}
+
+func truncateTag(v string) string {
+	if len(v) > receiverMaxTagLength {
+		return v[:receiverMaxTagLength]
+	}
+	return v
+}
+
+// toStatsd returns the tags to submit stats about a client library with
+func (t receiverTags) toStatsd() []string {
+	var tags []string
+	if t.Lang != "" {
+		tags = append(tags, "lang:"+t.Lang)
+	}
+	if t.LangVersion != "" {
+		tags = append(tags, "lang_version:"+t.LangVersion)
+	}
+	if t.TracerVersion != "" {
+		tags = append(tags, "tracer_version:"+t.TracerVersion)
+	}
+	return tags
+}
+
+// receiverTagStats holds the stats of the receiver by client library
+type receiverTagStats struct {
+	mu    sync.RWMutex
+	stats map[receiverTags]*receiverStats
+	idle  map[receiverTags]int // number of swaps the client library sent nothing in a row
+}
+
+func newReceiverTagStats() *receiverTagStats {
+	return &receiverTagStats{
+		stats: make(map[receiverTags]*receiverStats),
+		idle:  make(map[receiverTags]int),
+	}
+}
+
+// get returns the stats of the given client library, to be updated atomically
+func (s *receiverTagStats) get(tags receiverTags) *receiverStats {
+	s.mu.RLock()
+	rs, ok := s.stats[tags]
+	s.mu.RUnlock()
+	if ok {
+		return rs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rs, ok := s.stats[tags]; ok {
+		return rs
+	}
+	if len(s.stats) >= receiverMaxTagSets {
+		tags = tagsOther
+		if rs, ok := s.stats[tags]; ok {
+			return rs
+		}
+	}
+	rs = &receiverStats{}
+	s.stats[tags] = rs
+	return rs
+}
+
+// swap returns the stats accumulated since the last call by client library,
+// resetting them in place. Client libraries which did not send anything for
+// receiverMaxIdleSwaps calls in a row are forgotten: by then, the handlers
+// which got their stats from get are done updating them.
+func (s *receiverTagStats) swap() map[receiverTags]receiverStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[receiverTags]receiverStats, len(s.stats))
+	for tags, rs := range s.stats {
+		recent := rs.swap()
+		if recent == (receiverStats{}) {
+			s.idle[tags]++
+			if s.idle[tags] >= receiverMaxIdleSwaps {
+				delete(s.stats, tags)
+				delete(s.idle, tags)
+			}
+			continue
+		}
+		delete(s.idle, tags)
+		stats[tags] = recent
+	}
+	return stats
+}
+
+// receiverStats contains stats about the volume of data received
+type receiverStats struct {
+	// TracesBytes is the amount of data received on the traces endpoint (raw data, encoded, compressed).
+	TracesBytes int64
+	// ServicesBytes is the amount of data received on the services endpoint (raw data, encoded, compressed).
+	ServicesBytes int64
+	// TracesBytesUncompressed is the amount of data received on the traces endpoint, once decompressed.
+	TracesBytesUncompressed int64
+	// ServicesBytesUncompressed is the amount of data received on the services endpoint, once decompressed.
+	ServicesBytesUncompressed int64
+	// SpansReceived is the number of spans received, including the dropped ones
+	SpansReceived int64
+	// TracesReceived is the number of traces received, including the dropped ones
+	TracesReceived int64
+	// SpansDropped is the number of spans dropped
+	SpansDropped int64
+	// SpansReceived is the number of traces dropped
+	TracesDropped int64
+	// PayloadsRateLimited is the number of payloads rejected because we were
+	// saturated, clients being asked to retry them later
+	PayloadsRateLimited int64
+	// TracesRateLimited is the number of traces in those rejected payloads
+	TracesRateLimited int64
+	// PayloadsDecodingError is the number of payloads we could not decode
+	PayloadsDecodingError int64
+}
+
+// swap atomically resets the stats, returning their previous values
+func (rs *receiverStats) swap() receiverStats {
+	return receiverStats{
+		TracesBytes:               atomic.SwapInt64(&rs.TracesBytes, 0),
+		ServicesBytes:             atomic.SwapInt64(&rs.ServicesBytes, 0),
+		TracesBytesUncompressed:   atomic.SwapInt64(&rs.TracesBytesUncompressed, 0),
+		ServicesBytesUncompressed: atomic.SwapInt64(&rs.ServicesBytesUncompressed, 0),
+		SpansReceived:             atomic.SwapInt64(&rs.SpansReceived, 0),
+		TracesReceived:            atomic.SwapInt64(&rs.TracesReceived, 0),
+		SpansDropped:              atomic.SwapInt64(&rs.SpansDropped, 0),
+		TracesDropped:             atomic.SwapInt64(&rs.TracesDropped, 0),
+		PayloadsRateLimited:       atomic.SwapInt64(&rs.PayloadsRateLimited, 0),
+		TracesRateLimited:         atomic.SwapInt64(&rs.TracesRateLimited, 0),
+		PayloadsDecodingError:     atomic.SwapInt64(&rs.PayloadsDecodingError, 0),
+	}
+}
+
+// add adds other to the stats, which must not be updated concurrently
+func (rs *receiverStats) add(other receiverStats) {
+	rs.TracesBytes += other.TracesBytes
+	rs.ServicesBytes += other.ServicesBytes
+	rs.TracesBytesUncompressed += other.TracesBytesUncompressed
+	rs.ServicesBytesUncompressed += other.ServicesBytesUncompressed
+	rs.SpansReceived += other.SpansReceived
+	rs.TracesReceived += other.TracesReceived
+	rs.SpansDropped += other.SpansDropped
+	rs.TracesDropped += other.TracesDropped
+	rs.PayloadsRateLimited += other.PayloadsRateLimited
+	rs.TracesRateLimited += other.TracesRateLimited
+	rs.PayloadsDecodingError += other.PayloadsDecodingError
+}
+
+// tagStats are the stats of a client library, as published in expvar
+type tagStats struct {
+	receiverTags
+	receiverStats
+}
+
+// sortedTagStats returns stats by client library as a list, sorted by tags
+func sortedTagStats(stats map[receiverTags]receiverStats) []tagStats {
+	list := make([]tagStats, 0, len(stats))
+	for tags, rs := range stats {
+		list = append(list, tagStats{tags, rs})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].receiverTags, list[j].receiverTags
+		if a.Lang != b.Lang {
+			return a.Lang < b.Lang
+		}
+		if a.LangVersion != b.LangVersion {
+			return a.LangVersion < b.LangVersion
+		}
+		return a.TracerVersion < b.TracerVersion
+	})
+	return list
+}
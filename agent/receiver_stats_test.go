@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+)
+
+func TestReceiverTagsFromRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("POST", "/v0.4/traces", nil)
+	assert.Equal(receiverTags{}, receiverTagsFromRequest(req))
+	assert.Nil(receiverTags{}.toStatsd())
+
+	req.Header.Set("Datadog-Meta-Lang", "python")
+	req.Header.Set("Datadog-Meta-Lang-Version", "2.7.14")
+	req.Header.Set("Datadog-Meta-Tracer-Version", strings.Repeat("0", 100))
+	tags := receiverTagsFromRequest(req)
+	assert.Equal(receiverTags{"python", "2.7.14", strings.Repeat("0", receiverMaxTagLength)}, tags)
+	assert.Equal([]string{"lang:python", "lang_version:2.7.14", "tracer_version:" + tags.TracerVersion}, tags.toStatsd())
+}
+
+func TestReceiverTagStats(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newReceiverTagStats()
+	goTags := receiverTags{"go", "go1.9.2", "0.5.1"}
+	rs := s.get(goTags)
+	assert.True(rs == s.get(goTags))
+	rs.TracesReceived = 3
+
+	// beyond the cap, new client libraries share their stats
+	for i := 1; i < receiverMaxTagSets; i++ {
+		s.get(receiverTags{Lang: "go", TracerVersion: fmt.Sprint(i)}).TracesReceived = 1
+	}
+	other := s.get(receiverTags{Lang: "ruby"})
+	assert.True(other == s.get(receiverTags{Lang: "java"}))
+	assert.True(other == s.get(tagsOther))
+	other.TracesDropped = 2
+
+	stats := s.swap()
+	assert.Len(stats, receiverMaxTagSets+1)
+	assert.Equal(receiverStats{TracesReceived: 3}, stats[goTags])
+	assert.Equal(receiverStats{TracesDropped: 2}, stats[tagsOther])
+	assert.Equal(receiverStats{}, *rs)
+
+	// client libraries which did not send anything are kept for a while, as
+	// handlers may still be updating the stats they got just before the swap
+	s.get(goTags).TracesReceived = 1
+	stats = s.swap()
+	assert.Len(stats, 1)
+	assert.Len(s.stats, receiverMaxTagSets+1)
+	assert.Equal(receiverStats{TracesReceived: 1}, stats[goTags])
+	other.TracesDropped = 1
+	stats = s.swap()
+	assert.Len(stats, 1)
+	assert.Equal(receiverStats{TracesDropped: 1}, stats[tagsOther])
+
+	assert.True(rs == s.get(goTags))
+
+	// then forgotten, after two swaps without anything
+	assert.Len(s.stats, 2)
+	stats = s.swap()
+	assert.Len(stats, 0)
+	assert.Len(s.stats, 1)
+	stats = s.swap()
+	assert.Len(stats, 0)
+	assert.Len(s.stats, 0)
+}
+
+func TestReceiverStatsAdd(t *testing.T) {
+	assert := assert.New(t)
+
+	rs := receiverStats{TracesReceived: 1, SpansReceived: 2, PayloadsDecodingError: 1}
+	rs.add(receiverStats{TracesReceived: 2, TracesDropped: 1, PayloadsDecodingError: 1})
+	assert.Equal(receiverStats{TracesReceived: 3, SpansReceived: 2, TracesDropped: 1, PayloadsDecodingError: 2}, rs)
+}
+
+func TestSortedTagStats(t *testing.T) {
+	assert := assert.New(t)
+
+	sorted := sortedTagStats(map[receiverTags]receiverStats{
+		{"python", "2.7.14", "0.10.0"}: {TracesReceived: 1},
+		{"go", "go1.9.2", "0.5.1"}:     {TracesReceived: 2},
+		{"go", "go1.9.2", "0.5.0"}:     {TracesReceived: 3},
+		{}:                             {TracesReceived: 4},
+	})
+	var traces []int64
+	for _, ts := range sorted {
+		traces = append(traces, ts.TracesReceived)
+	}
+	assert.Equal([]int64{4, 3, 2, 1}, traces)
+}
+
+func TestReceiverStatsByClientLibrary(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v04, r.handleTraces)))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, fixtures.GetTestTrace(2, 1)))
+	payload := buf.Bytes()
+
+	post := func(lang string, data []byte) {
+		req, err := http.NewRequest("POST", server.URL, bytes.NewReader(data))
+		assert.Nil(err)
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set("Datadog-Meta-Lang", lang)
+		req.Header.Set("Datadog-Meta-Lang-Version", "1.0")
+		req.Header.Set("Datadog-Meta-Tracer-Version", "0.1.0")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		resp.Body.Close()
+	}
+
+	post("go", payload)
+	post("go", payload)
+	post("ruby", payload)
+	post("ruby", []byte("not msgpack"))
+
+	stats := r.stats.swap()
+	assert.Len(stats, 2)
+	goStats := stats[receiverTags{"go", "1.0", "0.1.0"}]
+	assert.Equal(int64(4), goStats.TracesReceived)
+	assert.Equal(int64(4), goStats.SpansReceived)
+	assert.Equal(int64(0), goStats.PayloadsDecodingError)
+	rubyStats := stats[receiverTags{"ruby", "1.0", "0.1.0"}]
+	assert.Equal(int64(2), rubyStats.TracesReceived)
+	assert.Equal(int64(1), rubyStats.PayloadsDecodingError)
+}
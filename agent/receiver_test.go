@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
+	"expvar"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,6 +24,10 @@ import (
 	"github.com/tinylib/msgp/msgp"
 )
 
+func newTestReceiverFromConfig(conf *config.AgentConfig) *HTTPReceiver {
+	return NewHTTPReceiver(conf, config.NewDynamicConfig())
+}
+
 func TestReceiverRequestBodyLength(t *testing.T) {
 	assert := assert.New(t)
 
@@ -26,7 +38,7 @@ func TestReceiverRequestBodyLength(t *testing.T) {
 	defaultMux := http.DefaultServeMux
 	http.DefaultServeMux = http.NewServeMux()
 
-	receiver := NewHTTPReceiver(conf)
+	receiver := newTestReceiverFromConfig(conf)
 	receiver.maxRequestBodyLength = 2
 	go receiver.Run()
 
@@ -73,6 +85,207 @@ func TestReceiverRequestBodyLength(t *testing.T) {
 	testBody(http.StatusRequestEntityTooLarge, " []")
 }
 
+func TestReceiverUnixSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-socket")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apm.socket")
+
+	// a socket left over by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	assert.Nil(err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	conf.ReceiverSocket = path
+	conf.ReceiverSocketPerm = 0660
+	// only listening on the socket
+	conf.ReceiverPort = 0
+
+	// save the global mux aside, we don't want to break other tests
+	defaultMux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+	http.Handle("/debug/vars", expvar.Handler())
+
+	receiver := newTestReceiverFromConfig(conf)
+	go receiver.Run()
+
+	defer func() {
+		close(receiver.exit)
+		// we need to wait more than on second (time for StoppableListener.Accept
+		// to acknowledge the connection has been closed)
+		time.Sleep(2 * time.Second)
+		http.DefaultServeMux = defaultMux
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+	}
+
+	// Before going further, make sure receiver is started
+	// since it's running in another goroutine
+	var resp *http.Response
+	for i := 0; i < 10; i++ {
+		resp, err = client.Post("http://unix/v0.4/traces", "application/json", bytes.NewBufferString("[]"))
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	fi, err := os.Stat(path)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0660), fi.Mode().Perm())
+
+	// debug vars are served on the socket as well
+	resp, err = client.Get("http://unix/debug/vars")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestReceiverUnixSocketNotASocket(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "trace-agent-socket")
+	assert.Nil(err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	receiver := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	err = receiver.ListenUnix(f.Name(), 0666)
+	assert.NotNil(err)
+
+	// the regular file was left untouched
+	_, err = os.Stat(f.Name())
+	assert.Nil(err)
+}
+
+func TestReceiverCompressedPayload(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.NewDefaultAgentConfig()
+
+	var payload bytes.Buffer
+	err := msgp.Encode(&payload, fixtures.GetTestTrace(1, 1))
+	assert.Nil(err)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(payload.Bytes())
+	gz.Close()
+
+	var deflated bytes.Buffer
+	zl := zlib.NewWriter(&deflated)
+	zl.Write(payload.Bytes())
+	zl.Close()
+
+	var rawDeflated bytes.Buffer
+	fl, _ := flate.NewWriter(&rawDeflated, flate.BestSpeed)
+	fl.Write(payload.Bytes())
+	fl.Close()
+
+	testCases := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"no encoding", "", payload.Bytes()},
+		{"gzip", "gzip", gzipped.Bytes()},
+		{"deflate", "deflate", deflated.Bytes()},
+		{"raw deflate", "deflate", rawDeflated.Bytes()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestReceiverFromConfig(conf)
+			server := httptest.NewServer(
+				http.HandlerFunc(r.httpHandleWithVersion(v03, r.handleTraces)),
+			)
+			defer server.Close()
+
+			req, err := http.NewRequest("POST", server.URL, bytes.NewReader(tc.body))
+			assert.Nil(err)
+			req.Header.Set("Content-Type", "application/msgpack")
+			req.Header.Set("Content-Encoding", tc.encoding)
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(err)
+			resp.Body.Close()
+			assert.Equal(200, resp.StatusCode)
+
+			select {
+			case rt := <-r.traces:
+				assert.Len(rt.trace, 1)
+				assert.Equal(uint64(42), rt.trace[0].TraceID)
+			default:
+				t.Fatalf("no data received")
+			}
+
+			// both the wire and the decompressed sizes are accounted for
+			assert.Equal(int64(len(tc.body)), r.stats.get(receiverTags{}).TracesBytes)
+			assert.Equal(int64(payload.Len()), r.stats.get(receiverTags{}).TracesBytesUncompressed)
+		})
+	}
+}
+
+func TestReceiverCompressedPayloadErrors(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.NewDefaultAgentConfig()
+	r := newTestReceiverFromConfig(conf)
+	r.maxRequestBodyLength = 4096
+
+	server := httptest.NewServer(
+		http.HandlerFunc(r.httpHandleWithVersion(v03, r.handleTraces)),
+	)
+	defer server.Close()
+
+	// a zip bomb: small once compressed, way over the limit when decompressed
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	gz.Write(bytes.Repeat([]byte(" "), 1024*1024))
+	gz.Write([]byte("[]"))
+	gz.Close()
+	assert.True(bomb.Len() < 4096)
+
+	testCases := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"unsupported encoding", "br", []byte("[]"), http.StatusUnsupportedMediaType},
+		{"invalid gzip data", "gzip", []byte("[]"), http.StatusBadRequest},
+		{"zip bomb", "gzip", bomb.Bytes(), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", server.URL, bytes.NewReader(tc.body))
+			assert.Nil(err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", tc.encoding)
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(err)
+			resp.Body.Close()
+			assert.Equal(tc.status, resp.StatusCode)
+		})
+	}
+}
+
 func TestLegacyReceiver(t *testing.T) {
 	// testing traces without content-type in agent endpoints, it should use JSON decoding
 	assert := assert.New(t)
@@ -84,8 +297,8 @@ func TestLegacyReceiver(t *testing.T) {
 		contentType string
 		traces      model.Trace
 	}{
-		{"v01 with empty content-type", NewHTTPReceiver(config), v01, "", model.Trace{fixtures.GetTestSpan()}},
-		{"v01 with application/json", NewHTTPReceiver(config), v01, "application/json", model.Trace{fixtures.GetTestSpan()}},
+		{"v01 with empty content-type", newTestReceiverFromConfig(config), v01, "", model.Trace{fixtures.GetTestSpan()}},
+		{"v01 with application/json", newTestReceiverFromConfig(config), v01, "application/json", model.Trace{fixtures.GetTestSpan()}},
 	}
 
 	for _, tc := range testCases {
@@ -110,8 +323,8 @@ func TestLegacyReceiver(t *testing.T) {
 			// now we should be able to read the trace data
 			select {
 			case rt := <-tc.r.traces:
-				assert.Len(rt, 1)
-				span := rt[0]
+				assert.Len(rt.trace, 1)
+				span := rt.trace[0]
 				assert.Equal(uint64(42), span.TraceID)
 				assert.Equal(uint64(52), span.SpanID)
 				assert.Equal("fennel_is_amazing", span.Service)
@@ -140,12 +353,16 @@ func TestReceiverJSONDecoder(t *testing.T) {
 		contentType string
 		traces      []model.Trace
 	}{
-		{"v02 with empty content-type", NewHTTPReceiver(config), v02, "", fixtures.GetTestTrace(1, 1)},
-		{"v03 with empty content-type", NewHTTPReceiver(config), v03, "", fixtures.GetTestTrace(1, 1)},
-		{"v02 with application/json", NewHTTPReceiver(config), v02, "application/json", fixtures.GetTestTrace(1, 1)},
-		{"v03 with application/json", NewHTTPReceiver(config), v03, "application/json", fixtures.GetTestTrace(1, 1)},
-		{"v02 with text/json", NewHTTPReceiver(config), v02, "text/json", fixtures.GetTestTrace(1, 1)},
-		{"v03 with text/json", NewHTTPReceiver(config), v03, "text/json", fixtures.GetTestTrace(1, 1)},
+		{"v02 with empty content-type", newTestReceiverFromConfig(config), v02, "", fixtures.GetTestTrace(1, 1)},
+		{"v03 with empty content-type", newTestReceiverFromConfig(config), v03, "", fixtures.GetTestTrace(1, 1)},
+		{"v02 with application/json", newTestReceiverFromConfig(config), v02, "application/json", fixtures.GetTestTrace(1, 1)},
+		{"v03 with application/json", newTestReceiverFromConfig(config), v03, "application/json", fixtures.GetTestTrace(1, 1)},
+		{"v02 with text/json", newTestReceiverFromConfig(config), v02, "text/json", fixtures.GetTestTrace(1, 1)},
+		{"v03 with text/json", newTestReceiverFromConfig(config), v03, "text/json", fixtures.GetTestTrace(1, 1)},
+		{"v04 with empty content-type", newTestReceiverFromConfig(config), v04, "", fixtures.GetTestTrace(1, 1)},
+		{"v04 with application/json", newTestReceiverFromConfig(config), v04, "application/json", fixtures.GetTestTrace(1, 1)},
+		{"v04 with text/json", newTestReceiverFromConfig(config), v04, "text/json", fixtures.GetTestTrace(1, 1)},
+		{"v04 with a charset", newTestReceiverFromConfig(config), v04, "application/json; charset=utf-8", fixtures.GetTestTrace(1, 1)},
 	}
 
 	for _, tc := range testCases {
@@ -170,8 +387,8 @@ func TestReceiverJSONDecoder(t *testing.T) {
 			// now we should be able to read the trace data
 			select {
 			case rt := <-tc.r.traces:
-				assert.Len(rt, 1)
-				span := rt[0]
+				assert.Len(rt.trace, 1)
+				span := rt.trace[0]
 				assert.Equal(uint64(42), span.TraceID)
 				assert.Equal(uint64(52), span.SpanID)
 				assert.Equal("fennel_is_amazing", span.Service)
@@ -189,6 +406,283 @@ func TestReceiverJSONDecoder(t *testing.T) {
 	}
 }
 
+func TestReceiverUnsupportedMediaType(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.NewDefaultAgentConfig()
+
+	for _, v := range []APIVersion{v02, v03, v04} {
+		for _, handler := range []string{"traces", "services"} {
+			r := newTestReceiverFromConfig(conf)
+			h := r.handleTraces
+			if handler == "services" {
+				h = r.handleServices
+			}
+			server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v, h)))
+
+			req, err := http.NewRequest("POST", server.URL, bytes.NewBufferString("<traces/>"))
+			assert.Nil(err)
+			req.Header.Set("Content-Type", "application/xml")
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(err)
+			assert.Equal(http.StatusUnsupportedMediaType, resp.StatusCode, "%s %s", v, handler)
+			resp.Body.Close()
+			server.Close()
+
+			assert.Len(r.traces, 0)
+		}
+	}
+}
+
+func TestReceiverZipkinSpans(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandle(r.handleZipkinSpans)))
+	defer server.Close()
+
+	payload := `[
+		{"traceId": "000000000000000a", "id": "000000000000000a", "name": "get /users", "kind": "SERVER",
+		 "timestamp": 1502787600000000, "duration": 1500, "localEndpoint": {"serviceName": "frontend"}},
+		{"traceId": "000000000000000a", "id": "000000000000000b", "parentId": "000000000000000a", "name": "select",
+		 "kind": "CLIENT", "timestamp": 1502787600000100, "duration": 900, "localEndpoint": {"serviceName": "frontend"},
+		 "tags": {"error": "timeout"}}
+	]`
+	resp, err := http.Post(server.URL, "application/json", bytes.NewBufferString(payload))
+	assert.Nil(err)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	resp.Body.Close()
+
+	select {
+	case rt := <-r.traces:
+		assert.Len(rt.trace, 2)
+		for _, span := range rt.trace {
+			assert.Equal(uint64(10), span.TraceID)
+			assert.Equal("frontend", span.Service)
+			if span.SpanID == 11 {
+				assert.Equal(uint64(10), span.ParentID)
+				assert.Equal(int32(1), span.Error)
+				assert.Equal("timeout", span.Meta["error.msg"])
+			}
+		}
+	default:
+		t.Fatalf("no data received")
+	}
+	assert.Equal(int64(1), r.stats.get(receiverTags{}).TracesReceived)
+	assert.Equal(int64(2), r.stats.get(receiverTags{}).SpansReceived)
+
+	// protobuf is not supported
+	resp, err = http.Post(server.URL, "application/x-protobuf", bytes.NewBufferString(payload))
+	assert.Nil(err)
+	assert.Equal(http.StatusUnsupportedMediaType, resp.StatusCode)
+	resp.Body.Close()
+
+	// invalid IDs are rejected
+	resp, err = http.Post(server.URL, "application/json", bytes.NewBufferString(`[{"traceId": "xyz", "id": "1"}]`))
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestReceiverJaegerBatch(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandle(r.handleJaegerBatch)))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	err := fixtures.EncodeJaegerBatch(&buf, &model.JaegerBatch{
+		Process: model.JaegerProcess{ServiceName: "frontend"},
+		Spans: []model.JaegerSpan{
+			{TraceIDLow: 10, SpanID: 10, OperationName: "HTTP GET", StartTime: 1502787600000000, Duration: 1500},
+			{TraceIDLow: 10, SpanID: 11, ParentSpanID: 10, OperationName: "query", StartTime: 1502787600000100, Duration: 900},
+		},
+	})
+	assert.Nil(err)
+	payload := buf.Bytes()
+
+	resp, err := http.Post(server.URL+"?format=jaeger.thrift", "application/x-thrift", bytes.NewReader(payload))
+	assert.Nil(err)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	resp.Body.Close()
+
+	select {
+	case rt := <-r.traces:
+		assert.Len(rt.trace, 2)
+		for _, span := range rt.trace {
+			assert.Equal(uint64(10), span.TraceID)
+			assert.Equal("frontend", span.Service)
+		}
+	default:
+		t.Fatalf("no data received")
+	}
+	assert.Equal(int64(1), r.stats.get(receiverTags{}).TracesReceived)
+	assert.Equal(int64(2), r.stats.get(receiverTags{}).SpansReceived)
+
+	// only the binary protocol is supported
+	resp, err = http.Post(server.URL, "application/json", bytes.NewReader(payload))
+	assert.Nil(err)
+	assert.Equal(http.StatusUnsupportedMediaType, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL, "application/x-thrift", bytes.NewReader(payload[:10]))
+	assert.Nil(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestReceiverSaturated(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	r.traces = make(chan receivedTrace, 4)
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v04, r.handleTraces)))
+	defer server.Close()
+
+	post := func(traces model.Traces) *http.Response {
+		data, err := json.Marshal(traces)
+		assert.Nil(err)
+		resp, err := http.Post(server.URL, "application/json", bytes.NewBuffer(data))
+		assert.Nil(err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post(model.Traces{fixtures.GetTestTrace(1, 1)[0], fixtures.GetTestTrace(1, 1)[0], fixtures.GetTestTrace(1, 1)[0]})
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(r.traces, 3)
+
+	// there is room for 1 trace only, the whole payload is rejected
+	resp = post(fixtures.GetTestTrace(2, 1))
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal("2", resp.Header.Get("Retry-After"))
+	assert.Len(r.traces, 3)
+	assert.Equal(int64(1), r.stats.get(receiverTags{}).PayloadsRateLimited)
+	assert.Equal(int64(2), r.stats.get(receiverTags{}).TracesRateLimited)
+	assert.Equal(int64(3), r.stats.get(receiverTags{}).TracesReceived)
+	assert.Equal(int64(0), r.stats.get(receiverTags{}).TracesDropped)
+
+	resp = post(fixtures.GetTestTrace(1, 1))
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(r.traces, 4)
+
+	// once the queue is drained, clients are welcome again, unless we are
+	// pre-sampling and the queue is more than half full
+	<-r.traces
+	<-r.traces
+	r.preSampler.SetRate(0.5)
+	resp = post(fixtures.GetTestTrace(1, 1))
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp = post(fixtures.GetTestTrace(1, 1))
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(int64(2), r.stats.get(receiverTags{}).PayloadsRateLimited)
+}
+
+func TestReceiverSaturatedBigPayload(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestReceiverFromConfig(config.NewDefaultAgentConfig())
+	r.traces = make(chan receivedTrace, 4)
+	server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v04, r.handleTraces)))
+	defer server.Close()
+
+	post := func(traces model.Traces) *http.Response {
+		data, err := json.Marshal(traces)
+		assert.Nil(err)
+		resp, err := http.Post(server.URL, "application/json", bytes.NewBuffer(data))
+		assert.Nil(err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// a payload bigger than the queue is taken when there is no backlog,
+	// instead of having the client retry it forever
+	resp := post(fixtures.GetTestTrace(6, 1))
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(r.traces, 4)
+	assert.Equal(int64(2), r.stats.get(receiverTags{}).TracesDropped)
+	assert.Equal(int64(0), r.stats.get(receiverTags{}).PayloadsRateLimited)
+
+	// and pushed back when there is one
+	<-r.traces
+	resp = post(fixtures.GetTestTrace(6, 1))
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(int64(6), r.stats.get(receiverTags{}).TracesRateLimited)
+
+	for len(r.traces) > 1 {
+		<-r.traces
+	}
+	resp = post(fixtures.GetTestTrace(6, 1))
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(r.traces, 4)
+}
+
+func TestReceiverRejectionDetails(t *testing.T) {
+	assert := assert.New(t)
+
+	traces := model.Traces{
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+	}
+	traces[0][0].TraceID = 1
+	traces[1][0].TraceID = 2
+	traces[1][0].Service = ""
+	traces[2][0].TraceID = 3
+	traces[2][0].SpanID = 0
+	data, err := json.Marshal(traces)
+	assert.Nil(err)
+
+	post := func(r *HTTPReceiver, v APIVersion, details bool) map[string]interface{} {
+		server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v, r.handleTraces)))
+		defer server.Close()
+
+		req, err := http.NewRequest("POST", server.URL, bytes.NewReader(data))
+		assert.Nil(err)
+		req.Header.Set("Content-Type", "application/json")
+		if details {
+			req.Header.Set(RejectionDetailsHeader, "true")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		assert.Len(r.traces, 1)
+
+		var body map[string]interface{}
+		if details {
+			assert.Equal("application/json", resp.Header.Get("Content-Type"))
+			assert.Nil(json.NewDecoder(resp.Body).Decode(&body))
+		}
+		return body
+	}
+
+	conf := config.NewDefaultAgentConfig()
+	r := newTestReceiverFromConfig(conf)
+	body := post(r, v03, true)
+	_, ok := body["rate_by_service"]
+	assert.False(ok)
+	rejected := body["rejected"].([]interface{})
+	if assert.Len(rejected, 2) {
+		first := rejected[0].(map[string]interface{})
+		assert.Equal(float64(1), first["index"])
+		assert.Equal(float64(2), first["trace_id"])
+		assert.Contains(first["error"], "empty `Service`")
+		second := rejected[1].(map[string]interface{})
+		assert.Equal(float64(2), second["index"])
+		assert.Equal(float64(3), second["trace_id"])
+		assert.Contains(second["error"], "empty `SpanID`")
+	}
+
+	// v0.4 also returns the rates by service
+	r = newTestReceiverFromConfig(conf)
+	r.dynConf.RateByService.SetAll(map[string]float64{"service:mcnulty,env:prod": 0.5})
+	body = post(r, v04, true)
+	assert.Equal(map[string]interface{}{"service:mcnulty,env:prod": 0.5}, body["rate_by_service"])
+	assert.Len(body["rejected"], 2)
+
+	// nothing changes for the clients which do not opt in
+	r = newTestReceiverFromConfig(conf)
+	post(r, v03, false)
+}
+
 func TestReceiverMsgpackDecoder(t *testing.T) {
 	// testing traces without content-type in agent endpoints, it should use Msgpack decoding
 	// or it should raise a 415 Unsupported media type
@@ -201,9 +695,10 @@ func TestReceiverMsgpackDecoder(t *testing.T) {
 		contentType string
 		traces      model.Traces
 	}{
-		{"v01 with application/msgpack", NewHTTPReceiver(config), v01, "application/msgpack", fixtures.GetTestTrace(1, 1)},
-		{"v02 with application/msgpack", NewHTTPReceiver(config), v02, "application/msgpack", fixtures.GetTestTrace(1, 1)},
-		{"v03 with application/msgpack", NewHTTPReceiver(config), v03, "application/msgpack", fixtures.GetTestTrace(1, 1)},
+		{"v01 with application/msgpack", newTestReceiverFromConfig(config), v01, "application/msgpack", fixtures.GetTestTrace(1, 1)},
+		{"v02 with application/msgpack", newTestReceiverFromConfig(config), v02, "application/msgpack", fixtures.GetTestTrace(1, 1)},
+		{"v03 with application/msgpack", newTestReceiverFromConfig(config), v03, "application/msgpack", fixtures.GetTestTrace(1, 1)},
+		{"v04 with application/msgpack", newTestReceiverFromConfig(config), v04, "application/msgpack", fixtures.GetTestTrace(1, 1)},
 	}
 
 	for _, tc := range testCases {
@@ -230,14 +725,14 @@ func TestReceiverMsgpackDecoder(t *testing.T) {
 				assert.Equal(415, resp.StatusCode)
 			case v02:
 				assert.Equal(415, resp.StatusCode)
-			case v03:
+			case v03, v04:
 				assert.Equal(200, resp.StatusCode)
 
 				// now we should be able to read the trace data
 				select {
 				case rt := <-tc.r.traces:
-					assert.Len(rt, 1)
-					span := rt[0]
+					assert.Len(rt.trace, 1)
+					span := rt.trace[0]
 					assert.Equal(uint64(42), span.TraceID)
 					assert.Equal(uint64(52), span.SpanID)
 					assert.Equal("fennel_is_amazing", span.Service)
@@ -256,6 +751,130 @@ func TestReceiverMsgpackDecoder(t *testing.T) {
 	}
 }
 
+func TestReceiverMsgpackStream(t *testing.T) {
+	assert := assert.New(t)
+
+	traces := model.Traces{
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+		fixtures.GetTestTrace(1, 1)[0],
+	}
+	traces[1][0].Service = ""
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, traces))
+	data := buf.Bytes()
+
+	post := func(r *HTTPReceiver, data []byte, details bool) *http.Response {
+		server := httptest.NewServer(http.HandlerFunc(r.httpHandleWithVersion(v04, r.handleTraces)))
+		defer server.Close()
+
+		req, err := http.NewRequest("POST", server.URL, bytes.NewReader(data))
+		assert.Nil(err)
+		req.Header.Set("Content-Type", "application/msgpack")
+		if details {
+			req.Header.Set(RejectionDetailsHeader, "true")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		return resp
+	}
+
+	conf := config.NewDefaultAgentConfig()
+	r := newTestReceiverFromConfig(conf)
+	resp := post(r, data, true)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var body struct {
+		Rejected []traceRejection `json:"rejected"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	if assert.Len(body.Rejected, 1) {
+		assert.Equal(1, body.Rejected[0].Index)
+	}
+	assert.Len(r.traces, 2)
+
+	// the traces before a malformed one are processed anyway, and the payload
+	// acknowledged so that clients do not send them again
+	r = newTestReceiverFromConfig(conf)
+	resp = post(r, data[:len(data)-10], false)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(r.traces, 1)
+	ts := r.stats.get(receiverTags{})
+	assert.Equal(int64(3), ts.TracesReceived)
+	assert.Equal(int64(2), ts.TracesDropped)
+	assert.Equal(int64(1), ts.PayloadsDecodingError)
+
+	// the undecoded traces are rejected along with the invalid ones
+	r = newTestReceiverFromConfig(conf)
+	resp = post(r, data[:len(data)-10], true)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	body.Rejected = nil
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	if assert.Len(body.Rejected, 2) {
+		assert.Equal(1, body.Rejected[0].Index)
+		assert.Equal(2, body.Rejected[1].Index)
+		assert.Contains(body.Rejected[1].Error, "decoding error")
+	}
+	assert.Len(r.traces, 1)
+}
+
+func TestReceiverRateByService(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.NewDefaultAgentConfig()
+	dynConf := config.NewDynamicConfig()
+	r := NewHTTPReceiver(conf, dynConf)
+
+	dynConf.RateByService.SetAll(map[string]float64{
+		"service:mcnulty,env:prod": 0.25,
+		"service:bunk,env:none":    1,
+	})
+
+	testCases := []struct {
+		name       string
+		apiVersion APIVersion
+		expected   string
+	}{
+		{"v03 acknowledges with OK", v03, "OK\n"},
+		{"v04 returns the rate by service", v04, `{"rate_by_service":{"service:bunk,env:none":1,"service:mcnulty,env:prod":0.25}}` + "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(
+				http.HandlerFunc(r.httpHandleWithVersion(tc.apiVersion, r.handleTraces)),
+			)
+			defer server.Close()
+
+			var buf bytes.Buffer
+			err := msgp.Encode(&buf, fixtures.GetTestTrace(1, 1))
+			assert.Nil(err)
+			req, err := http.NewRequest("POST", server.URL, &buf)
+			assert.Nil(err)
+			req.Header.Set("Content-Type", "application/msgpack")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			assert.Nil(err)
+			defer resp.Body.Close()
+			assert.Equal(200, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.Nil(err)
+			assert.Equal(tc.expected, string(body))
+
+			// the trace went through anyway
+			select {
+			case rt := <-r.traces:
+				assert.Len(rt.trace, 1)
+			default:
+				t.Fatalf("no data received")
+			}
+		})
+	}
+}
+
 func TestReceiverServiceJSONDecoder(t *testing.T) {
 	// testing traces without content-type in agent endpoints, it should use JSON decoding
 	assert := assert.New(t)
@@ -266,15 +885,15 @@ func TestReceiverServiceJSONDecoder(t *testing.T) {
 		apiVersion  APIVersion
 		contentType string
 	}{
-		{"v01 with empty content-type", NewHTTPReceiver(config), v01, ""},
-		{"v02 with empty content-type", NewHTTPReceiver(config), v02, ""},
-		{"v03 with empty content-type", NewHTTPReceiver(config), v03, ""},
-		{"v01 with application/json", NewHTTPReceiver(config), v01, "application/json"},
-		{"v02 with application/json", NewHTTPReceiver(config), v02, "application/json"},
-		{"v03 with application/json", NewHTTPReceiver(config), v03, "application/json"},
-		{"v01 with text/json", NewHTTPReceiver(config), v01, "text/json"},
-		{"v02 with text/json", NewHTTPReceiver(config), v02, "text/json"},
-		{"v03 with text/json", NewHTTPReceiver(config), v03, "text/json"},
+		{"v01 with empty content-type", newTestReceiverFromConfig(config), v01, ""},
+		{"v02 with empty content-type", newTestReceiverFromConfig(config), v02, ""},
+		{"v03 with empty content-type", newTestReceiverFromConfig(config), v03, ""},
+		{"v01 with application/json", newTestReceiverFromConfig(config), v01, "application/json"},
+		{"v02 with application/json", newTestReceiverFromConfig(config), v02, "application/json"},
+		{"v03 with application/json", newTestReceiverFromConfig(config), v03, "application/json"},
+		{"v01 with text/json", newTestReceiverFromConfig(config), v01, "text/json"},
+		{"v02 with text/json", newTestReceiverFromConfig(config), v02, "text/json"},
+		{"v03 with text/json", newTestReceiverFromConfig(config), v03, "text/json"},
 	}
 
 	for _, tc := range testCases {
@@ -337,9 +956,9 @@ func TestReceiverServiceMsgpackDecoder(t *testing.T) {
 		apiVersion  APIVersion
 		contentType string
 	}{
-		{"v01 with application/msgpack", NewHTTPReceiver(config), v01, "application/msgpack"},
-		{"v02 with application/msgpack", NewHTTPReceiver(config), v02, "application/msgpack"},
-		{"v03 with application/msgpack", NewHTTPReceiver(config), v03, "application/msgpack"},
+		{"v01 with application/msgpack", newTestReceiverFromConfig(config), v01, "application/msgpack"},
+		{"v02 with application/msgpack", newTestReceiverFromConfig(config), v02, "application/msgpack"},
+		{"v03 with application/msgpack", newTestReceiverFromConfig(config), v03, "application/msgpack"},
 	}
 
 	for _, tc := range testCases {
@@ -408,7 +1027,7 @@ func BenchmarkHandleTraces(b *testing.B) {
 
 	// prepare the receiver
 	config := config.NewDefaultAgentConfig()
-	receiver := NewHTTPReceiver(config)
+	receiver := newTestReceiverFromConfig(config)
 
 	// response recorder
 	handler := http.HandlerFunc(receiver.httpHandleWithVersion(v03, receiver.handleTraces))
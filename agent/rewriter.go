@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// TraceRewriter rewrites the spans of traces with the configured rules, such
+// as to remove the hostnames embedded in services or the IDs in resources,
+// which would otherwise make the cardinality of the stats explode.
+type TraceRewriter struct {
+	rules  []*rewriteRule
+	dryRun bool // if true, the changes the rules would make are only logged
+}
+
+type rewriteRule struct {
+	name    string
+	service string // if set, the rule only applies to the spans of this service
+	field   spanField
+	re      *regexp.Regexp
+	replace string
+
+	// in dry run mode, the spans the rule would have changed since the last
+	// stats, and the first change, logged as an example
+	wouldRewrite int64
+	exampleFrom  string
+	exampleTo    string
+}
+
+// NewTraceRewriter creates a new TraceRewriter from the configured rules.
+// Invalid rules are logged and skipped.
+func NewTraceRewriter(conf *config.AgentConfig) *TraceRewriter {
+	tr := &TraceRewriter{dryRun: conf.RewriteDryRun}
+	for _, rule := range conf.RewriteRules {
+		field, err := parseSpanField(rule.Field)
+		if err != nil {
+			log.Errorf("invalid rewrite rule %s: %v", rule.Name, err)
+			continue
+		}
+		if rule.Match == "" {
+			log.Errorf("invalid rewrite rule %s: no regex to match", rule.Name)
+			continue
+		}
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			log.Errorf("invalid rewrite rule %s: %v", rule.Name, err)
+			continue
+		}
+		tr.rules = append(tr.rules, &rewriteRule{
+			name:    rule.Name,
+			service: rule.Service,
+			field:   field,
+			re:      re,
+			replace: rule.Replace,
+		})
+	}
+	return tr
+}
+
+// Rewrite applies the rules to all the spans of a normalized trace, in order
+func (tr *TraceRewriter) Rewrite(t model.Trace) {
+	if len(tr.rules) == 0 {
+		return
+	}
+	for i := range t {
+		for _, r := range tr.rules {
+			tr.apply(r, &t[i])
+		}
+	}
+}
+
+func (tr *TraceRewriter) apply(r *rewriteRule, s *model.Span) {
+	if r.service != "" && r.service != s.Service {
+		return
+	}
+	v, ok := r.field.get(s)
+	if !ok || !r.re.MatchString(v) {
+		return
+	}
+
+	nv := r.re.ReplaceAllString(v, r.replace)
+	// spans were normalized before being rewritten, keep them so
+	switch r.field.name {
+	case "service":
+		if len(nv) > model.MaxServiceLen {
+			nv = ""
+		}
+		nv = model.NormalizeTag(nv)
+	case "name":
+		var ok bool
+		if nv, ok = model.NormalizeName(nv); !ok {
+			nv = ""
+		}
+	case "resource":
+		if len(nv) > model.MaxResourceLen {
+			nv = nv[:model.MaxResourceLen]
+		}
+	}
+	if nv == v {
+		return
+	}
+	if nv == "" && r.field.name != "meta" {
+		log.Debugf("rewrite rule %s would leave span %d with an empty or invalid %s, skipping it", r.name, s.SpanID, r.field)
+		return
+	}
+
+	if tr.dryRun {
+		// logged in logStats, not to flood the logs
+		if r.wouldRewrite == 0 {
+			r.exampleFrom, r.exampleTo = v, nv
+		}
+		r.wouldRewrite++
+		return
+	}
+	r.field.set(s, nv)
+}
+
+// logStats logs, in dry run mode, how many spans each rule would have changed
+// since the last call
+func (tr *TraceRewriter) logStats() {
+	for _, r := range tr.rules {
+		if r.wouldRewrite == 0 {
+			continue
+		}
+		log.Infof("rewrite rule %s would have changed the %s of %d spans, e.g. from %q to %q",
+			r.name, r.field, r.wouldRewrite, r.exampleFrom, r.exampleTo)
+		r.wouldRewrite = 0
+		r.exampleFrom, r.exampleTo = "", ""
+	}
+}
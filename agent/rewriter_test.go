@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRewriter(dryRun bool) *TraceRewriter {
+	conf := config.NewDefaultAgentConfig()
+	conf.RewriteDryRun = dryRun
+	conf.RewriteRules = []config.RewriteRule{
+		{Name: "web-hosts", Field: "service", Match: "^web-i-[0-9a-f]+$", Replace: "web"},
+		{Name: "user-ids", Service: "web", Field: "resource", Match: "/users/[0-9]+", Replace: "/users/?"},
+		{Name: "urls", Service: "web", Field: "meta.http.url", Match: "^(https?)://[^/]+", Replace: "$1://host"},
+		{Name: "bad-field", Field: "duration", Match: ".*", Replace: "0"},
+		{Name: "bad-regex", Field: "name", Match: "[a-z", Replace: ""},
+	}
+	return NewTraceRewriter(conf)
+}
+
+func TestTraceRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := newTestRewriter(false)
+	assert.Len(tr.rules, 3)
+
+	trace := model.Trace{
+		{SpanID: 1, Service: "web-i-0abc123", Name: "http.request", Resource: "GET /users/42/orders",
+			Meta: map[string]string{"http.url": "http://10.0.3.7:8080/users/42/orders"}},
+		// other services are left alone by the rules scoped to web
+		{SpanID: 2, ParentID: 1, Service: "auth", Name: "http.request", Resource: "GET /users/42",
+			Meta: map[string]string{"http.url": "http://auth/users/42"}},
+		// a missing meta is not set
+		{SpanID: 3, ParentID: 1, Service: "web", Name: "template.render", Resource: "users/7"},
+	}
+	tr.Rewrite(trace)
+
+	assert.Equal("web", trace[0].Service)
+	assert.Equal("GET /users/?/orders", trace[0].Resource)
+	assert.Equal("http://host/users/42/orders", trace[0].Meta["http.url"])
+	assert.Equal("auth", trace[1].Service)
+	assert.Equal("GET /users/42", trace[1].Resource)
+	assert.Equal("http://auth/users/42", trace[1].Meta["http.url"])
+	assert.Equal("users/7", trace[2].Resource)
+	assert.Nil(trace[2].Meta)
+}
+
+func TestTraceRewriterDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := newTestRewriter(true)
+	trace := model.Trace{
+		{SpanID: 1, Service: "web-i-0abc123", Name: "http.request", Resource: "GET /users/42"},
+	}
+	tr.Rewrite(trace)
+	tr.Rewrite(model.Trace{{SpanID: 2, Service: "web-i-0def456", Name: "http.request", Resource: "GET /"}})
+
+	assert.Equal("web-i-0abc123", trace[0].Service)
+	assert.Equal("GET /users/42", trace[0].Resource)
+
+	// the changes are counted, to be logged periodically rather than one by one
+	r := tr.rules[0]
+	assert.Equal(int64(2), r.wouldRewrite)
+	assert.Equal("web-i-0abc123", r.exampleFrom)
+	assert.Equal("web", r.exampleTo)
+	tr.logStats()
+	assert.Equal(int64(0), r.wouldRewrite)
+	assert.Equal("", r.exampleFrom)
+}
+
+func TestTraceRewriterNoEmptyValues(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.RewriteRules = []config.RewriteRule{
+		{Name: "drop-service", Field: "service", Match: ".*", Replace: ""},
+		{Name: "drop-url", Field: "meta.http.url", Match: ".*", Replace: ""},
+	}
+	tr := NewTraceRewriter(conf)
+
+	trace := model.Trace{
+		{SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /", Meta: map[string]string{"http.url": "/"}},
+	}
+	tr.Rewrite(trace)
+
+	assert.Equal("web", trace[0].Service)
+	assert.Equal("", trace[0].Meta["http.url"])
+}
+
+func TestTraceRewriterNormalizes(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.RewriteRules = []config.RewriteRule{
+		{Name: "long-service", Service: "auth", Field: "service", Match: ".*", Replace: strings.Repeat("a", model.MaxServiceLen+1)},
+		{Name: "service", Service: "web", Field: "service", Match: "web", Replace: "Web Frontend"},
+		{Name: "empty-name", Service: "web-frontend", Field: "name", Match: "^http", Replace: "..."},
+		{Name: "name", Field: "name", Match: "^sql", Replace: "SQL Query!"},
+		{Name: "long-resource", Field: "resource", Match: "SELECT", Replace: strings.Repeat("x", model.MaxResourceLen)},
+	}
+	tr := NewTraceRewriter(conf)
+
+	trace := model.Trace{
+		{SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /"},
+		{SpanID: 2, ParentID: 1, Service: "auth", Name: "sql.query", Resource: "SELECT 1"},
+	}
+	tr.Rewrite(trace)
+
+	assert.Equal("web_frontend", trace[0].Service)
+	assert.Equal("http.request", trace[0].Name)
+	assert.Equal("auth", trace[1].Service)
+	assert.Equal("SQL_Query.query", trace[1].Name)
+	assert.Equal(strings.Repeat("x", model.MaxResourceLen), trace[1].Resource)
+}
+
+func TestAgentProcessRewrite(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "key"
+	conf.RewriteRules = []config.RewriteRule{
+		{Name: "web-hosts", Field: "service", Match: "^web-i-[0-9a-f]+$", Replace: "web"},
+	}
+	a := NewAgent(conf)
+
+	now := model.Now()
+	a.Process(model.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web-i-0abc123", Name: "http.request", Resource: "GET /", Start: now - 1000, Duration: 1000},
+	}, receiverTags{})
+	a.processing.Wait()
+
+	buckets := a.Concentrator.FlushAll()
+	if !assert.Len(buckets, 1) {
+		t.FailNow()
+	}
+	for _, c := range buckets[0].Counts {
+		assert.Equal("web", c.TagSet.Get("service").Value)
+	}
+}
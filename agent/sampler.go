@@ -19,7 +19,12 @@ type Sampler struct {
 	traceCount    int
 	lastFlush     time.Time
 
+	// count of traces received and kept, by sampling priority set by the client
+	priorityCount map[int]int
+	priorityKept  map[int]int
+
 	samplerEngine SamplerEngine
+	dynConf       *config.DynamicConfig
 }
 
 // samplerStats contains sampler statistics
@@ -28,6 +33,10 @@ type samplerStats struct {
 	KeptTPS float64
 	// TotalTPS is the total number of traces (average per second for last flush)
 	TotalTPS float64
+	// PriorityTPS is the number of traces with a sampling priority, by priority (average per second for last flush)
+	PriorityTPS map[int]float64
+	// PriorityKeptTPS is the number of traces kept, by sampling priority (average per second for last flush)
+	PriorityKeptTPS map[int]float64
 }
 
 type samplerInfo struct {
@@ -45,11 +54,14 @@ type SamplerEngine interface {
 }
 
 // NewSampler creates a new empty sampler ready to be started
-func NewSampler(conf *config.AgentConfig) *Sampler {
+func NewSampler(conf *config.AgentConfig, dynConf *config.DynamicConfig) *Sampler {
 	return &Sampler{
 		sampledTraces: []model.Trace{},
 		traceCount:    0,
+		priorityCount: make(map[int]int),
+		priorityKept:  make(map[int]int),
 		samplerEngine: sampler.NewSampler(conf.ExtraSampleRate, conf.MaxTPS),
+		dynConf:       dynConf,
 	}
 }
 
@@ -65,9 +77,16 @@ func (s *Sampler) Run() {
 func (s *Sampler) Add(t processedTrace) {
 	s.mu.Lock()
 	s.traceCount++
-	if s.samplerEngine.Sample(t.Trace, t.Root, t.Env) {
+	sampled := s.samplerEngine.Sample(t.Trace, t.Root, t.Env)
+	if sampled {
 		s.sampledTraces = append(s.sampledTraces, t.Trace)
 	}
+	if priority, ok := sampler.GetSamplingPriority(t.Root); ok {
+		s.priorityCount[priority]++
+		if sampled {
+			s.priorityKept[priority]++
+		}
+	}
 	s.mu.Unlock()
 }
 
@@ -84,6 +103,10 @@ func (s *Sampler) Flush() []model.Trace {
 	s.sampledTraces = []model.Trace{}
 	traceCount := s.traceCount
 	s.traceCount = 0
+	priorityCount := s.priorityCount
+	s.priorityCount = make(map[int]int)
+	priorityKept := s.priorityKept
+	s.priorityKept = make(map[int]int)
 
 	now := time.Now()
 	duration := now.Sub(s.lastFlush)
@@ -91,11 +114,19 @@ func (s *Sampler) Flush() []model.Trace {
 
 	s.mu.Unlock()
 
-	state := s.samplerEngine.(*sampler.Sampler).GetState()
+	engine := s.samplerEngine.(*sampler.Sampler)
+	state := engine.GetState()
 	var stats samplerStats
 	if duration > 0 {
 		stats.KeptTPS = float64(len(traces)) / duration.Seconds()
 		stats.TotalTPS = float64(traceCount) / duration.Seconds()
+
+		stats.PriorityTPS = make(map[int]float64, len(priorityCount))
+		stats.PriorityKeptTPS = make(map[int]float64, len(priorityCount))
+		for priority, count := range priorityCount {
+			stats.PriorityTPS[priority] = float64(count) / duration.Seconds()
+			stats.PriorityKeptTPS[priority] = float64(priorityKept[priority]) / duration.Seconds()
+		}
 	}
 
 	log.Debugf("flushed %d sampled traces out of %d", len(traces), traceCount)
@@ -105,5 +136,13 @@ func (s *Sampler) Flush() []model.Trace {
 	// publish through expvar
 	updateSamplerInfo(samplerInfo{Stats: stats, State: state})
 
+	// advertise the up-to-date rates to the clients, through the receiver
+	serviceRates := engine.GetServiceSampleRates()
+	rates := make(map[string]float64, len(serviceRates))
+	for sig, rate := range serviceRates {
+		rates[sig.String()] = rate
+	}
+	s.dynConf.RateByService.SetAll(rates)
+
 	return traces
 }
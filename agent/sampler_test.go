@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func TestSamplerPriorityStats(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	s := NewSampler(conf, config.NewDynamicConfig())
+	s.lastFlush = time.Now().Add(-10 * time.Second)
+
+	addTrace := func(priority float64, hasPriority bool) {
+		trace := fixtures.RandomTrace(3, 2)
+		root := trace.GetRoot()
+		if hasPriority {
+			if root.Metrics == nil {
+				root.Metrics = make(map[string]float64)
+			}
+			root.Metrics[model.SamplingPriorityKey] = priority
+		}
+		s.Add(processedTrace{Trace: trace, Root: root, Env: "none"})
+	}
+
+	for i := 0; i < 20; i++ {
+		addTrace(0, true)
+	}
+	for i := 0; i < 10; i++ {
+		addTrace(1, true)
+	}
+	addTrace(0, false)
+
+	traces := s.Flush()
+	assert.True(len(traces) >= 10, "all traces with priority 1 should be kept")
+
+	stats := publishSamplerInfo().(samplerInfo).Stats
+	assert.Len(stats.PriorityTPS, 2)
+	assert.InEpsilon(2.0, stats.PriorityTPS[0], 0.01)
+	assert.InEpsilon(1.0, stats.PriorityTPS[1], 0.01)
+	assert.Equal(0.0, stats.PriorityKeptTPS[0])
+	assert.InEpsilon(1.0, stats.PriorityKeptTPS[1], 0.01)
+
+	// counters are reset on flush
+	s.Flush()
+	stats = publishSamplerInfo().(samplerInfo).Stats
+	assert.Len(stats.PriorityTPS, 0)
+}
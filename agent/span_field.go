@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// spanFieldMetaPrefix prefixes the span fields referring to a meta key
+const spanFieldMetaPrefix = "meta."
+
+// spanField is a field of spans that rules from the config can refer to:
+// service, name, resource or meta.<key>
+type spanField struct {
+	name    string // service, name, resource or meta
+	metaKey string // if name is meta
+}
+
+func parseSpanField(field string) (spanField, error) {
+	switch {
+	case field == "service", field == "name", field == "resource":
+		return spanField{name: field}, nil
+	case strings.HasPrefix(field, spanFieldMetaPrefix) && len(field) > len(spanFieldMetaPrefix):
+		return spanField{name: "meta", metaKey: field[len(spanFieldMetaPrefix):]}, nil
+	}
+	return spanField{}, fmt.Errorf("invalid span field %q, it should be service, name, resource or meta.<key>", field)
+}
+
+func (f spanField) String() string {
+	if f.name == "meta" {
+		return spanFieldMetaPrefix + f.metaKey
+	}
+	return f.name
+}
+
+// get returns the value of the field in a span, and if it is set at all
+func (f spanField) get(s *model.Span) (string, bool) {
+	switch f.name {
+	case "service":
+		return s.Service, true
+	case "name":
+		return s.Name, true
+	case "resource":
+		return s.Resource, true
+	case "meta":
+		v, ok := s.Meta[f.metaKey]
+		return v, ok
+	}
+	return "", false
+}
+
+// set sets the value of the field in a span
+func (f spanField) set(s *model.Span, v string) {
+	switch f.name {
+	case "service":
+		s.Service = v
+	case "name":
+		s.Name = v
+	case "resource":
+		s.Resource = v
+	case "meta":
+		if s.Meta == nil {
+			s.Meta = make(map[string]string, 1)
+		}
+		s.Meta[f.metaKey] = v
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// tlsReloadInterval is how often we check if the certificates changed on disk
+const tlsReloadInterval = 10 * time.Second
+
+// tlsReloader provides the TLS configuration of the receiver, reloading the
+// certificates from disk when they change, so that they can be renewed
+// without restarting the agent.
+type tlsReloader struct {
+	certFile, keyFile, caFile string
+	clientAuth                bool
+	checkInterval             time.Duration
+
+	mu        sync.Mutex
+	config    *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newTLSReloader(conf *config.AgentConfig) (*tlsReloader, error) {
+	if conf.ReceiverTLSCertFile == "" || conf.ReceiverTLSKeyFile == "" {
+		return nil, errors.New("TLS requires both a certificate and a key file")
+	}
+	tr := &tlsReloader{
+		certFile:      conf.ReceiverTLSCertFile,
+		keyFile:       conf.ReceiverTLSKeyFile,
+		caFile:        conf.ReceiverTLSCAFile,
+		clientAuth:    conf.ReceiverTLSClientAuth,
+		checkInterval: tlsReloadInterval,
+	}
+	if tr.clientAuth && tr.caFile == "" {
+		return nil, errors.New("TLS client authentication requires a CA file")
+	}
+	if err := tr.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// serverConfig returns the TLS configuration the server listens with. The
+// actual configuration is picked for each connection, to follow reloads.
+func (tr *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return tr.current(time.Now()), nil
+		},
+	}
+}
+
+// current returns the TLS configuration to use, reloading it if the files
+// changed. If they cannot be loaded, the previous configuration is kept.
+func (tr *tlsReloader) current(now time.Time) *tls.Config {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if now.Sub(tr.lastCheck) >= tr.checkInterval {
+		tr.lastCheck = now
+		if tr.changed() {
+			if err := tr.loadLocked(now); err != nil {
+				log.Errorf("cannot reload TLS certificates, keeping the previous ones: %v", err)
+			} else {
+				log.Info("reloaded TLS certificates")
+			}
+		}
+	}
+
+	return tr.config
+}
+
+func (tr *tlsReloader) files() []string {
+	files := []string{tr.certFile, tr.keyFile}
+	if tr.caFile != "" {
+		files = append(files, tr.caFile)
+	}
+	return files
+}
+
+func (tr *tlsReloader) changed() bool {
+	for i, f := range tr.files() {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(tr.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr *tlsReloader) load(now time.Time) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.loadLocked(now)
+}
+
+func (tr *tlsReloader) loadLocked(now time.Time) error {
+	var modTimes []time.Time
+	for _, f := range tr.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(tr.certFile, tr.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %v", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if tr.caFile != "" {
+		pem, err := ioutil.ReadFile(tr.caFile)
+		if err != nil {
+			return fmt.Errorf("cannot read TLS CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in TLS CA file %s", tr.caFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+		if tr.clientAuth {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	tr.config = conf
+	tr.modTimes = modTimes
+	tr.lastCheck = now
+	return nil
+}
+
+// clientCommonName returns the CN of the certificate the client of a request
+// authenticated with, if it was verified.
+func clientCommonName(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// tagClientCommonName sets the CN of the client certificate on all the spans
+// of the traces, if configured to.
+func (r *HTTPReceiver) tagClientCommonName(traces model.Traces, req *http.Request) {
+	key := r.conf.ReceiverTLSClientCNTag
+	if key == "" {
+		return
+	}
+	cn := clientCommonName(req)
+	if cn == "" {
+		return
+	}
+	for i := range traces {
+		for j := range traces[i] {
+			if traces[i][j].Meta == nil {
+				traces[i][j].Meta = make(map[string]string, 1)
+			}
+			traces[i][j].Meta[key] = cn
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+)
+
+// testCert is a certificate and its key, signed by parent (self-signed if nil)
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeTestCert writes the certificate and its key to dir, with the given modification time
+func writeTestCert(t *testing.T, dir string, c *testCert, mtime time.Time) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key")
+	for file, data := range map[string][]byte{certFile: c.certPEM(), keyFile: c.keyPEM(t)} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, mtime, mtime)
+	}
+	return certFile, keyFile
+}
+
+func TestReceiverTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-tls")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.Nil(ioutil.WriteFile(caFile, ca.certPEM(), 0600))
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.cert)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.ReceiverTLSCertFile, conf.ReceiverTLSKeyFile = writeTestCert(t, dir, newTestCert(t, "agent-1", ca), time.Now().Add(-time.Minute))
+	conf.ReceiverTLSCAFile = caFile
+	conf.ReceiverTLSClientAuth = true
+	conf.ReceiverTLSClientCNTag = "client.cn"
+
+	// save the global mux aside, we don't want to break other tests
+	defaultMux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+
+	r := newTestReceiverFromConfig(conf)
+	http.HandleFunc("/v0.4/traces", r.httpHandleWithVersion(v04, r.handleTraces))
+
+	reloader, err := newTLSReloader(conf)
+	assert.Nil(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	assert.Nil(r.serve(listener, reloader.serverConfig()))
+
+	defer func() {
+		close(r.exit)
+		// we need to wait more than on second (time for StoppableListener.Accept
+		// to acknowledge the connection has been closed)
+		time.Sleep(2 * time.Second)
+		http.DefaultServeMux = defaultMux
+	}()
+
+	url := "https://" + listener.Addr().String() + "/v0.4/traces"
+	post := func(clientCerts []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: caPool, Certificates: clientCerts},
+			DisableKeepAlives: true,
+		}}
+		data, err := json.Marshal(fixtures.GetTestTrace(1, 1))
+		assert.Nil(err)
+		resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// clients without a certificate are rejected
+	_, err = post(nil)
+	assert.NotNil(err)
+
+	// and so are the ones with a certificate we don't trust
+	_, err = post([]tls.Certificate{newTestCert(t, "stranger", nil).tlsCertificate(t)})
+	assert.NotNil(err)
+
+	clientCert := newTestCert(t, "mcnulty", ca).tlsCertificate(t)
+	resp, err := post([]tls.Certificate{clientCert})
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("agent-1", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	select {
+	case rt := <-r.traces:
+		assert.Equal("mcnulty", rt.trace[0].Meta["client.cn"])
+	default:
+		t.Fatalf("no data received")
+	}
+
+	// the new certificate is picked up without restarting
+	reloader.checkInterval = 0
+	writeTestCert(t, dir, newTestCert(t, "agent-2", ca), time.Now())
+	resp, err = post([]tls.Certificate{clientCert})
+	if assert.Nil(err) {
+		assert.Equal("agent-2", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+
+	// a broken certificate is not, the previous one is kept
+	assert.Nil(ioutil.WriteFile(conf.ReceiverTLSKeyFile, []byte("garbage"), 0600))
+	os.Chtimes(conf.ReceiverTLSKeyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	resp, err = post([]tls.Certificate{clientCert})
+	if assert.Nil(err) {
+		assert.Equal("agent-2", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+}
+
+func TestNewTLSReloaderErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	_, err := newTLSReloader(conf)
+	assert.NotNil(err)
+
+	conf.ReceiverTLSCertFile = "/does/not/exist.crt"
+	conf.ReceiverTLSKeyFile = "/does/not/exist.key"
+	_, err = newTLSReloader(conf)
+	assert.NotNil(err)
+
+	conf.ReceiverTLSClientAuth = true
+	_, err = newTLSReloader(conf)
+	assert.Contains(err.Error(), "CA file")
+}
@@ -137,18 +137,53 @@ func (w *Writer) main() {
 			}
 		case <-w.exit:
 			log.Info("exiting, trying to flush all remaining data")
+			w.drainPayloads()
 			w.Flush()
 			return
 		}
 	}
 }
 
+// drainPayloads buffers the payloads sent to us right before we were stopped
+func (w *Writer) drainPayloads() {
+	for {
+		select {
+		case p := <-w.inPayloads:
+			if !p.IsEmpty() {
+				w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(p, w.endpoint))
+			}
+		default:
+			return
+		}
+	}
+}
+
 // Stop stops the main Run loop
 func (w *Writer) Stop() {
 	close(w.exit)
 	w.exitWG.Wait()
 }
 
+// StopTimeout stops the main Run loop like Stop, but gives up waiting for the
+// remaining data to be flushed after the given timeout. It returns false if
+// it gave up.
+func (w *Writer) StopTimeout(timeout time.Duration) bool {
+	close(w.exit)
+
+	done := make(chan struct{})
+	go func() {
+		w.exitWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // FlushServices initiate a flush of the services to the services endpoint
 func (w *Writer) FlushServices() {
 	w.endpoint.WriteServices(w.serviceBuffer)
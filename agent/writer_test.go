@@ -245,3 +245,49 @@ func TestWriterDisabledBuffering(t *testing.T) {
 	// dropped and the buffer should be empty.
 	assert.Equal(0, len(w.payloadBuffer))
 }
+
+func TestWriterStopTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 1)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+
+	w := NewWriter(conf)
+	w.inPayloads = make(chan model.AgentPayload, 1)
+	w.Run()
+
+	// sent right before stopping, it must be flushed anyway
+	w.inPayloads <- newTestPayload("test")
+	assert.True(w.StopTimeout(time.Second))
+
+	select {
+	case received := <-data:
+		assert.Equal("/api/v0.1/collector", received.urlPath)
+	default:
+		t.Fatal("the payload was not flushed on stop")
+	}
+}
+
+func TestWriterStopTimeoutHanging(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+
+	w := NewWriter(conf)
+	w.Run()
+	w.inPayloads <- newTestPayload("test")
+
+	assert.False(t, w.StopTimeout(100*time.Millisecond))
+}
@@ -26,6 +26,9 @@ type AgentConfig struct {
 	// Global
 	HostName   string
 	DefaultEnv string // the traces will default to this environment
+	// ShutdownTimeout is how long we try to process and send the data we
+	// still hold when exiting, before giving up on it.
+	ShutdownTimeout time.Duration
 
 	// API
 	APIEndpoint             string
@@ -36,6 +39,41 @@ type AgentConfig struct {
 	// Concentrator
 	BucketInterval   time.Duration // the size of our pre-aggregation per bucket
 	ExtraAggregators []string
+	// ExtraMeasures lists the keys of the span metrics aggregated as counts
+	// and distributions, along with hits, errors and duration
+	ExtraMeasures []string
+	// MaxErrorTypes is the maximum number of distinct error types for which
+	// errors are counted in a grain, the others being counted as _other
+	MaxErrorTypes int
+	// MaxGrainsPerService is the maximum number of distinct grains of a
+	// service in a stats bucket, beyond which its spans are aggregated with
+	// the _other resource. There is no limit if 0.
+	MaxGrainsPerService int
+	// ApdexThreshold is the duration under which top-level spans satisfy
+	// their users, unless overridden for their service or resource. Apdex
+	// counts are not computed if 0.
+	ApdexThreshold time.Duration
+	ApdexOverrides []ApdexOverride
+	// InternalSpanStats tells if stats are computed for all spans. If not,
+	// only top-level spans and the ones marked as measured get their own
+	// stats, the others only contributing to the sublayer stats.
+	InternalSpanStats bool
+
+	// Ignore holds the regexes whose matching traces are dropped before any
+	// processing, by field of their root span: service, name, resource or
+	// meta.<key>.
+	Ignore map[string][]string
+
+	// RewriteRules rewrite the spans of traces before they are quantized and
+	// aggregated, in order. If RewriteDryRun is set, the changes they would
+	// make are only logged.
+	RewriteRules  []RewriteRule
+	RewriteDryRun bool
+
+	// Obfuscation rules scrub sensitive meta values before traces are sampled,
+	// if enabled, which is not the case by default
+	ObfuscationEnabled bool
+	ObfuscationRules   []ObfuscationRule
 
 	// Sampler configuration
 	ExtraSampleRate float64
@@ -44,9 +82,31 @@ type AgentConfig struct {
 
 	// Receiver
 	ReceiverHost    string
-	ReceiverPort    int
+	ReceiverPort    int // 0 disables the TCP listener, only allowed if ReceiverSocket is set
 	ConnectionLimit int // for rate-limiting, how many unique connections to allow in a lease period (30s)
 	ReceiverTimeout int
+	// ReceiverSocket is the path of the Unix domain socket the receiver listens on, in
+	// addition to the TCP port, or instead of it. Empty means no socket is opened.
+	ReceiverSocket     string
+	ReceiverSocketPerm os.FileMode // file permissions of the socket, readable/writable by all by default
+	// ReceiverTLSPort is the port of the HTTPS listener, 0 disables it.
+	ReceiverTLSPort       int
+	ReceiverTLSCertFile   string
+	ReceiverTLSKeyFile    string
+	ReceiverTLSCAFile     string // CA bundle to verify client certificates with
+	ReceiverTLSClientAuth bool   // if true, clients must present a certificate signed by the CA
+	// ReceiverTLSClientCNTag is the meta key under which the CN of the client
+	// certificate is set on received spans, empty disables it.
+	ReceiverTLSClientCNTag string
+	// ReceiverClientMaxSPS is the number of spans per second each client may
+	// send, 0 disables per-client rate limiting.
+	ReceiverClientMaxSPS   float64
+	ReceiverClientMaxBurst int    // spans a client may send at once after being idle, defaults to one second worth
+	ReceiverClientIDHeader string // header identifying clients, they are identified by their remote IP if empty or not set
+
+	// Trace assembler
+	AssemblerTimeout  time.Duration // how long we wait for the root of traces which arrive in several payloads, 0 disables it
+	AssemblerMaxSpans int           // max number of spans waiting for the rest of their trace
 
 	// internal telemetry
 	StatsdHost string
@@ -67,6 +127,60 @@ type AgentConfig struct {
 	Proxy *ProxySettings
 }
 
+// RewriteRule replaces the matches of a regex in a field of spans
+type RewriteRule struct {
+	Name    string // the name of the rule, as in its config section
+	Service string // if set, the rule only applies to the spans of this service
+	Field   string // service, name, resource or meta.<key>
+	Match   string // the regex to replace the matches of
+	Replace string // the replacement template, which may refer to submatches such as $1
+}
+
+// rewriteSectionPrefix prefixes the config sections of rewrite rules
+const rewriteSectionPrefix = "trace.rewrite."
+
+// ApdexOverride overrides the Apdex threshold of a service, or of one of its
+// resources
+type ApdexOverride struct {
+	Name      string // the name of the override, as in its config section
+	Service   string
+	Resource  string // if set, the threshold only applies to this resource of the service
+	Threshold time.Duration
+}
+
+// apdexSectionPrefix prefixes the config sections of Apdex overrides
+const apdexSectionPrefix = "trace.apdex."
+
+// Obfuscation actions, what an ObfuscationRule does to the values it applies to
+const (
+	ObfuscateURLQuery       = "url_query"       // replaces the values of the query string of URLs with ?
+	ObfuscateURLCredentials = "url_credentials" // replaces the credentials of URLs or hosts with ?
+	ObfuscateRedact         = "redact"          // replaces the whole value with ?
+	ObfuscateRedisAuth      = "redis_auth"      // replaces the arguments of Redis AUTH commands with ?
+	ObfuscateScrub          = "scrub"           // replaces the matches of a regex with ?
+)
+
+// ObfuscationRule obfuscates the values of some meta keys of spans
+type ObfuscationRule struct {
+	Name     string   // the name of the rule, as in its config section
+	SpanType string   // if set, the rule only applies to the spans of this type
+	Keys     []string // the meta keys whose values are obfuscated
+	Action   string   // one of the Obfuscate* actions
+	Match    string   // the regex to scrub, for ObfuscateScrub
+}
+
+// obfuscationSectionPrefix prefixes the config sections of obfuscation rules
+const obfuscationSectionPrefix = "trace.obfuscation."
+
+// defaultObfuscationRules are the obfuscation rules applied unless overridden
+// by rules of the same name
+var defaultObfuscationRules = []ObfuscationRule{
+	{Name: "http-url-query", Keys: []string{"http.url"}, Action: ObfuscateURLQuery},
+	{Name: "url-credentials", Keys: []string{"http.url", "out.host"}, Action: ObfuscateURLCredentials},
+	{Name: "authorization", Keys: []string{"http.request.headers.authorization"}, Action: ObfuscateRedact},
+	{Name: "redis-auth", SpanType: "redis", Keys: []string{"redis.raw_command"}, Action: ObfuscateRedisAuth},
+}
+
 // mergeEnv applies overrides from environment variables to the trace agent configuration
 func mergeEnv(c *AgentConfig) {
 	if v := os.Getenv("DD_APM_ENABLED"); v == "true" {
@@ -98,6 +212,10 @@ func mergeEnv(c *AgentConfig) {
 		}
 	}
 
+	if v := os.Getenv("DD_RECEIVER_SOCKET"); v != "" {
+		c.ReceiverSocket = v
+	}
+
 	if v := os.Getenv("DD_BIND_HOST"); v != "" {
 		c.StatsdHost = v
 		c.ReceiverHost = v
@@ -156,6 +274,7 @@ func NewDefaultAgentConfig() *AgentConfig {
 		Enabled:                 true,
 		HostName:                hostname,
 		DefaultEnv:              "none",
+		ShutdownTimeout:         5 * time.Second,
 		APIEndpoint:             "https://trace.agent.datadoghq.com",
 		APIKey:                  "",
 		APIEnabled:              true,
@@ -164,6 +283,10 @@ func NewDefaultAgentConfig() *AgentConfig {
 		BucketInterval:   time.Duration(10) * time.Second,
 		ExtraAggregators: []string{"http.status_code"},
 
+		InternalSpanStats:   true,
+		MaxErrorTypes:       model.DefaultMaxErrorTypes,
+		MaxGrainsPerService: model.DefaultMaxGrainsPerService,
+
 		ExtraSampleRate: 1.0,
 		PreSampleRate:   1.0,
 		MaxTPS:          10,
@@ -172,6 +295,13 @@ func NewDefaultAgentConfig() *AgentConfig {
 		ReceiverPort:    8126,
 		ConnectionLimit: 2000,
 
+		ReceiverSocketPerm: 0666,
+
+		AssemblerMaxSpans: 100000,
+
+		ObfuscationEnabled: false,
+		ObfuscationRules:   append([]ObfuscationRule(nil), defaultObfuscationRules...),
+
 		StatsdHost: "localhost",
 		StatsdPort: 8125,
 
@@ -257,6 +387,10 @@ APM_CONF:
 		c.DefaultEnv = model.NormalizeTag(v)
 	}
 
+	if v, e := conf.GetInt("trace.config", "shutdown_timeout_seconds"); e == nil {
+		c.ShutdownTimeout = time.Duration(v) * time.Second
+	}
+
 	if v, _ := conf.Get("trace.config", "log_level"); v != "" {
 		c.LogLevel = v
 	}
@@ -301,6 +435,102 @@ APM_CONF:
 		log.Debug("No aggregator configuration, using defaults")
 	}
 
+	if v, e := conf.GetStrArray("trace.concentrator", "extra_measures", ","); e == nil {
+		for _, m := range v {
+			switch m = strings.TrimSpace(m); m {
+			case "":
+			case model.HITS, model.ERRORS, model.DURATION:
+				log.Errorf("invalid extra measure %q: it conflicts with a built-in measure", m)
+			default:
+				c.ExtraMeasures = append(c.ExtraMeasures, m)
+			}
+		}
+	}
+
+	if v, e := conf.GetInt("trace.concentrator", "max_error_types"); e == nil {
+		c.MaxErrorTypes = v
+	}
+
+	if v, e := conf.GetInt("trace.concentrator", "max_grains_per_service"); e == nil {
+		c.MaxGrainsPerService = v
+	}
+
+	if v, e := conf.GetInt("trace.apdex", "threshold_ms"); e == nil {
+		c.ApdexThreshold = time.Duration(v) * time.Millisecond
+	}
+
+	for _, s := range conf.instance.Sections() {
+		if !strings.HasPrefix(s.Name(), apdexSectionPrefix) {
+			continue
+		}
+		// only the keys of the section itself: as a child section of
+		// trace.apdex, its Key method would fall back to the default threshold.
+		// Missing or invalid thresholds are left to 0, and reported by the agent.
+		keys := s.KeysHash()
+		threshold, _ := strconv.Atoi(keys["threshold_ms"])
+		c.ApdexOverrides = append(c.ApdexOverrides, ApdexOverride{
+			Name:      strings.TrimPrefix(s.Name(), apdexSectionPrefix),
+			Service:   keys["service"],
+			Resource:  keys["resource"],
+			Threshold: time.Duration(threshold) * time.Millisecond,
+		})
+	}
+
+	if s, e := conf.GetSection("trace.ignore"); e == nil {
+		c.Ignore = make(map[string][]string)
+		for _, k := range s.Keys() {
+			// several regexes may be given for a field, one per line
+			for _, v := range strings.Split(k.Value(), "\n") {
+				if v = strings.TrimSpace(v); v != "" {
+					c.Ignore[k.Name()] = append(c.Ignore[k.Name()], v)
+				}
+			}
+		}
+	}
+
+	getBool(conf, "trace.rewrite", "dry_run", &c.RewriteDryRun)
+
+	for _, s := range conf.instance.Sections() {
+		if !strings.HasPrefix(s.Name(), rewriteSectionPrefix) {
+			continue
+		}
+		c.RewriteRules = append(c.RewriteRules, RewriteRule{
+			Name:    strings.TrimPrefix(s.Name(), rewriteSectionPrefix),
+			Service: s.Key("service").String(),
+			Field:   s.Key("field").String(),
+			Match:   s.Key("match").String(),
+			Replace: s.Key("replace").String(),
+		})
+	}
+
+	getBool(conf, "trace.obfuscation", "enabled", &c.ObfuscationEnabled)
+
+	for _, s := range conf.instance.Sections() {
+		if !strings.HasPrefix(s.Name(), obfuscationSectionPrefix) {
+			continue
+		}
+		rule := ObfuscationRule{
+			Name:     strings.TrimPrefix(s.Name(), obfuscationSectionPrefix),
+			SpanType: s.Key("span_type").String(),
+			Keys:     s.Key("keys").Strings(","),
+			Action:   s.Key("action").String(),
+			Match:    s.Key("match").String(),
+		}
+		// rules named after a default one replace it
+		replaced := false
+		for i := range c.ObfuscationRules {
+			if c.ObfuscationRules[i].Name == rule.Name {
+				c.ObfuscationRules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			c.ObfuscationRules = append(c.ObfuscationRules, rule)
+		}
+	}
+
+	getBool(conf, "trace.concentrator", "internal_span_stats", &c.InternalSpanStats)
+
 	if v, e := conf.GetFloat("trace.sampler", "extra_sample_rate"); e == nil {
 		c.ExtraSampleRate = v
 	}
@@ -323,6 +553,61 @@ APM_CONF:
 		c.ReceiverTimeout = v
 	}
 
+	if v, e := conf.Get("trace.receiver", "receiver_socket"); e == nil {
+		c.ReceiverSocket = v
+	}
+
+	if v, e := conf.GetInt("trace.receiver", "tls_port"); e == nil {
+		c.ReceiverTLSPort = v
+	}
+
+	if v, e := conf.Get("trace.receiver", "tls_cert_file"); e == nil {
+		c.ReceiverTLSCertFile = v
+	}
+
+	if v, e := conf.Get("trace.receiver", "tls_key_file"); e == nil {
+		c.ReceiverTLSKeyFile = v
+	}
+
+	if v, e := conf.Get("trace.receiver", "tls_ca_file"); e == nil {
+		c.ReceiverTLSCAFile = v
+	}
+
+	getBool(conf, "trace.receiver", "tls_client_auth", &c.ReceiverTLSClientAuth)
+
+	if v, e := conf.Get("trace.receiver", "tls_client_cn_tag"); e == nil {
+		c.ReceiverTLSClientCNTag = v
+	}
+
+	if v, e := conf.GetFloat("trace.receiver", "client_max_spans_per_second"); e == nil {
+		c.ReceiverClientMaxSPS = v
+	}
+
+	if v, e := conf.GetInt("trace.receiver", "client_max_spans_burst"); e == nil {
+		c.ReceiverClientMaxBurst = v
+	}
+
+	if v, e := conf.Get("trace.receiver", "client_id_header"); e == nil {
+		c.ReceiverClientIDHeader = v
+	}
+
+	if v, e := conf.Get("trace.receiver", "receiver_socket_permissions"); e == nil {
+		perm, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			log.Errorf("invalid receiver_socket_permissions %q, it should be an octal mode such as 0660: %v", v, err)
+		} else {
+			c.ReceiverSocketPerm = os.FileMode(perm) & os.ModePerm
+		}
+	}
+
+	if v, e := conf.GetFloat("trace.assembler", "timeout_seconds"); e == nil {
+		c.AssemblerTimeout = time.Duration(v * float64(time.Second))
+	}
+
+	if v, e := conf.GetInt("trace.assembler", "max_spans"); e == nil {
+		c.AssemblerMaxSpans = v
+	}
+
 	if v, e := conf.GetFloat("trace.watchdog", "max_memory"); e == nil {
 		c.MaxMemory = v
 	}
@@ -350,3 +635,18 @@ ENV_CONF:
 
 	return c, nil
 }
+
+// getBool sets dest to the boolean value of section/name, if it is set. Invalid
+// values are logged, and leave dest as it was.
+func getBool(conf *File, section, name string, dest *bool) {
+	v, err := conf.Get(section, name)
+	if err != nil {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Errorf("invalid %s %q in [%s], it should be true or false: %v", name, v, section, err)
+		return
+	}
+	*dest = b
+}
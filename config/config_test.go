@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -165,6 +166,261 @@ func TestEmptyExtraAggregatorsFromConfig(t *testing.T) {
 	assert.Equal([]string{"http.status_code"}, agentConfig.ExtraAggregators)
 }
 
+func TestInternalSpanStatsFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"internal_span_stats = false",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.False(agentConfig.InternalSpanStats)
+	assert.True(NewDefaultAgentConfig().InternalSpanStats)
+	for v, expected := range map[string]bool{
+		"False": false,
+		"0":     false,
+		"1":     true,
+		"flase": true, // invalid values keep the default
+		"no":    true,
+	} {
+		dd, _ = ini.Load([]byte("[trace.concentrator]\ninternal_span_stats = " + v))
+		conf = &File{instance: dd, Path: "whatever"}
+		agentConfig, _ = NewAgentConfig(conf, nil)
+		assert.Equal(expected, agentConfig.InternalSpanStats, v)
+	}
+}
+
+func TestExtraMeasuresFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"extra_measures = db.rows, hits,http.response.size,",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]string{"db.rows", "http.response.size"}, agentConfig.ExtraMeasures)
+	assert.Nil(NewDefaultAgentConfig().ExtraMeasures)
+}
+
+func TestMaxErrorTypesFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"max_error_types = 5",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(5, agentConfig.MaxErrorTypes)
+	assert.Equal(20, NewDefaultAgentConfig().MaxErrorTypes)
+}
+
+func TestMaxGrainsPerServiceFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"max_grains_per_service = 0",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(0, agentConfig.MaxGrainsPerService)
+	assert.Equal(1000, NewDefaultAgentConfig().MaxGrainsPerService)
+}
+
+func TestApdexFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.apdex]",
+		"threshold_ms = 300",
+		"[trace.apdex.web]",
+		"service = web",
+		"threshold_ms = 100",
+		"[trace.apdex.checkout]",
+		"service = web",
+		"resource = POST /checkout",
+		"threshold_ms = 2000",
+		"[trace.apdex.invalid]",
+		"service = db",
+		"threshold_ms = fast",
+		"[trace.apdex.no-threshold]",
+		"service = cache",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(300*time.Millisecond, agentConfig.ApdexThreshold)
+	assert.Equal([]ApdexOverride{
+		{Name: "web", Service: "web", Threshold: 100 * time.Millisecond},
+		{Name: "checkout", Service: "web", Resource: "POST /checkout", Threshold: 2 * time.Second},
+		{Name: "invalid", Service: "db"},
+		// not the threshold of trace.apdex, it is left to the agent to report
+		{Name: "no-threshold", Service: "cache"},
+	}, agentConfig.ApdexOverrides)
+	assert.Equal(time.Duration(0), NewDefaultAgentConfig().ApdexThreshold)
+}
+
+func TestReceiverSocketFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.receiver]",
+		"receiver_port = 0",
+		"receiver_socket = /var/run/datadog/apm.socket",
+		"receiver_socket_permissions = 0660",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(0, agentConfig.ReceiverPort)
+	assert.Equal("/var/run/datadog/apm.socket", agentConfig.ReceiverSocket)
+	assert.Equal(os.FileMode(0660), agentConfig.ReceiverSocketPerm)
+
+	defaultConfig := NewDefaultAgentConfig()
+	assert.Equal(8126, defaultConfig.ReceiverPort)
+	assert.Equal("", defaultConfig.ReceiverSocket)
+	assert.Equal(os.FileMode(0666), defaultConfig.ReceiverSocketPerm)
+}
+
+func TestReceiverSocketPermissionsFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	for perm, expected := range map[string]os.FileMode{
+		"0600":  0600,
+		"660":   0660,
+		"01777": 0777, // only the permission bits are kept
+		"0o660": 0666, // invalid, the default is kept
+		"0999":  0666,
+		"rw-":   0666,
+	} {
+		dd, _ := ini.Load([]byte(strings.Join([]string{
+			"[Main]",
+			"hostname = thing",
+			"api_key = apikey_12",
+			"[trace.receiver]",
+			"receiver_socket_permissions = " + perm,
+		}, "\n")))
+
+		conf := &File{instance: dd, Path: "whatever"}
+		agentConfig, _ := NewAgentConfig(conf, nil)
+		assert.Equal(expected, agentConfig.ReceiverSocketPerm, perm)
+	}
+}
+
+func TestIgnoreFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.ignore]",
+		`resource = """GET /ping`,
+		`GET /health"""`,
+		"meta.http.url = ^https?://[^/]+/healthz$",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(map[string][]string{
+		"resource":      []string{"GET /ping", "GET /health"},
+		"meta.http.url": []string{"^https?://[^/]+/healthz$"},
+	}, agentConfig.Ignore)
+}
+
+func TestRewriteRulesFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.rewrite]",
+		"dry_run = true",
+		"[trace.rewrite.web-hosts]",
+		"field = service",
+		"match = ^web-i-[0-9a-f]+$",
+		"replace = web",
+		"[trace.rewrite.user-ids]",
+		"service = web",
+		"field = resource",
+		"match = /users/([0-9]+)",
+		"replace = /users/?",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.True(agentConfig.RewriteDryRun)
+	assert.Equal([]RewriteRule{
+		{Name: "web-hosts", Field: "service", Match: "^web-i-[0-9a-f]+$", Replace: "web"},
+		{Name: "user-ids", Service: "web", Field: "resource", Match: "/users/([0-9]+)", Replace: "/users/?"},
+	}, agentConfig.RewriteRules)
+}
+
+func TestObfuscationRulesFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"[trace.obfuscation]",
+		"enabled = true",
+		"[trace.obfuscation.http-url-query]",
+		"keys =",
+		"[trace.obfuscation.session-tokens]",
+		"span_type = web",
+		"keys = http.url, session.id",
+		"action = scrub",
+		"match = token=[^&]+",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.True(agentConfig.ObfuscationEnabled)
+	assert.False(NewDefaultAgentConfig().ObfuscationEnabled, "off unless enabled")
+	if !assert.Len(agentConfig.ObfuscationRules, len(defaultObfuscationRules)+1) {
+		t.FailNow()
+	}
+	// the default rule is replaced, in place
+	assert.Equal(ObfuscationRule{Name: "http-url-query", Keys: []string{}}, agentConfig.ObfuscationRules[0])
+	assert.Equal(defaultObfuscationRules[1], agentConfig.ObfuscationRules[1])
+	assert.Equal(ObfuscationRule{
+		Name:     "session-tokens",
+		SpanType: "web",
+		Keys:     []string{"http.url", "session.id"},
+		Action:   ObfuscateScrub,
+		Match:    "token=[^&]+",
+	}, agentConfig.ObfuscationRules[len(defaultObfuscationRules)])
+	// defaults are not modified
+	assert.Equal([]string{"http.url"}, defaultObfuscationRules[0].Keys)
+}
+
 func TestConfigNewIfExists(t *testing.T) {
 	// The file does not exist: no error returned
 	conf, err := NewIfExists("/does-not-exist")
@@ -189,3 +445,33 @@ func TestGetHostname(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", h)
 }
+
+func TestBoolsFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	options := []struct {
+		section, name string
+		get           func(*AgentConfig) bool
+	}{
+		{"trace.rewrite", "dry_run", func(c *AgentConfig) bool { return c.RewriteDryRun }},
+		{"trace.obfuscation", "enabled", func(c *AgentConfig) bool { return c.ObfuscationEnabled }},
+		{"trace.receiver", "tls_client_auth", func(c *AgentConfig) bool { return c.ReceiverTLSClientAuth }},
+		{"trace.concentrator", "internal_span_stats", func(c *AgentConfig) bool { return c.InternalSpanStats }},
+	}
+	for _, o := range options {
+		def := o.get(NewDefaultAgentConfig())
+		for v, expected := range map[string]bool{
+			"true":  true,
+			"True":  true,
+			"1":     true,
+			"false": false,
+			"FALSE": false,
+			"0":     false,
+			"yes":   def, // invalid values keep the default
+		} {
+			dd, _ := ini.Load([]byte("[" + o.section + "]\n" + o.name + " = " + v))
+			agentConfig, _ := NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+			assert.Equal(expected, o.get(agentConfig), "%s = %s", o.name, v)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"sync"
+)
+
+// DynamicConfig contains configuration items which may change
+// dynamically over time, as opposed to AgentConfig which is
+// set once and for all at startup.
+type DynamicConfig struct {
+	// RateByService contains the rate for each service/env tuple,
+	// sent back to the clients so that they can sample at the source.
+	RateByService RateByService
+}
+
+// NewDynamicConfig creates a new dynamic config object.
+func NewDynamicConfig() *DynamicConfig {
+	return &DynamicConfig{}
+}
+
+// RateByService stores the sample rate per service. It is thread-safe, so
+// one can read/write on it concurrently, using getters and setters.
+type RateByService struct {
+	rates map[string]float64
+	mu    sync.RWMutex
+}
+
+// SetAll replaces the sample rates of all services, keyed by "service:<name>,env:<env>".
+// If a service/env is not in the map, then its previous entry is removed.
+func (rbs *RateByService) SetAll(rates map[string]float64) {
+	rbs.mu.Lock()
+	defer rbs.mu.Unlock()
+
+	rbs.rates = make(map[string]float64, len(rates))
+	for k, v := range rates {
+		rbs.rates[k] = v
+	}
+}
+
+// GetAll returns a copy of the sample rates of all services.
+func (rbs *RateByService) GetAll() map[string]float64 {
+	rbs.mu.RLock()
+	defer rbs.mu.RUnlock()
+
+	ret := make(map[string]float64, len(rbs.rates))
+	for k, v := range rbs.rates {
+		ret[k] = v
+	}
+
+	return ret
+}
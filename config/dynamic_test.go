@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateByServiceGetSet(t *testing.T) {
+	assert := assert.New(t)
+
+	var rbs RateByService
+	assert.Equal(map[string]float64{}, rbs.GetAll())
+
+	rates := map[string]float64{
+		"service:mcnulty,env:prod": 0.5,
+		"service:bunk,env:none":    1,
+	}
+	rbs.SetAll(rates)
+	assert.Equal(map[string]float64{
+		"service:mcnulty,env:prod": 0.5,
+		"service:bunk,env:none":    1,
+	}, rbs.GetAll())
+
+	// the map is copied, altering it afterwards has no effect
+	rates["service:bunk,env:none"] = 42
+	assert.Equal(1.0, rbs.GetAll()["service:bunk,env:none"])
+
+	// services which are not in the new map are forgotten
+	rbs.SetAll(map[string]float64{"service:mcnulty,env:prod": 0.2})
+	assert.Equal(map[string]float64{"service:mcnulty,env:prod": 0.2}, rbs.GetAll())
+
+	// what we get is a copy, it does not alter the internal state
+	rates = rbs.GetAll()
+	rates["service:mcnulty,env:prod"] = 42
+	assert.Equal(0.2, rbs.GetAll()["service:mcnulty,env:prod"])
+}
+
+func TestRateByServiceConcurrency(t *testing.T) {
+	var rbs RateByService
+
+	// run this with -race flag
+	done := make(chan struct{}, 2)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			rbs.SetAll(map[string]float64{"service:mcnulty,env:prod": float64(i) / 1000})
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			_ = rbs.GetAll()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
@@ -0,0 +1,214 @@
+package fixtures
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// Thrift types, as encoded by the binary protocol
+const (
+	thriftStop   byte = 0
+	thriftBool   byte = 2
+	thriftDouble byte = 4
+	thriftI32    byte = 8
+	thriftI64    byte = 10
+	thriftString byte = 11
+	thriftStruct byte = 12
+	thriftList   byte = 15
+)
+
+// EncodeJaegerBatch encodes a Jaeger batch with the Thrift binary protocol,
+// the way jaeger-client HTTPSender does, for the receiver to decode it.
+func EncodeJaegerBatch(w io.Writer, b *model.JaegerBatch) error {
+	tw := newThriftWriter(w)
+	encodeJaegerBatch(tw, b)
+	return tw.err
+}
+
+func encodeJaegerBatch(tw *thriftWriter, b *model.JaegerBatch) {
+	tw.writeFieldBegin(thriftStruct, 1)
+	encodeJaegerProcess(tw, &b.Process)
+	tw.writeFieldBegin(thriftList, 2)
+	tw.writeListBegin(thriftStruct, len(b.Spans))
+	for i := range b.Spans {
+		encodeJaegerSpan(tw, &b.Spans[i])
+	}
+	tw.writeFieldStop()
+}
+
+func encodeJaegerProcess(tw *thriftWriter, p *model.JaegerProcess) {
+	tw.writeFieldBegin(thriftString, 1)
+	tw.writeString(p.ServiceName)
+	if p.Tags != nil {
+		tw.writeFieldBegin(thriftList, 2)
+		encodeJaegerTags(tw, p.Tags)
+	}
+	tw.writeFieldStop()
+}
+
+func encodeJaegerSpan(tw *thriftWriter, s *model.JaegerSpan) {
+	tw.writeFieldBegin(thriftI64, 1)
+	tw.writeI64(s.TraceIDLow)
+	tw.writeFieldBegin(thriftI64, 2)
+	tw.writeI64(s.TraceIDHigh)
+	tw.writeFieldBegin(thriftI64, 3)
+	tw.writeI64(s.SpanID)
+	tw.writeFieldBegin(thriftI64, 4)
+	tw.writeI64(s.ParentSpanID)
+	tw.writeFieldBegin(thriftString, 5)
+	tw.writeString(s.OperationName)
+	if s.References != nil {
+		tw.writeFieldBegin(thriftList, 6)
+		tw.writeListBegin(thriftStruct, len(s.References))
+		for i := range s.References {
+			encodeJaegerSpanRef(tw, &s.References[i])
+		}
+	}
+	tw.writeFieldBegin(thriftI32, 7)
+	tw.writeI32(s.Flags)
+	tw.writeFieldBegin(thriftI64, 8)
+	tw.writeI64(s.StartTime)
+	tw.writeFieldBegin(thriftI64, 9)
+	tw.writeI64(s.Duration)
+	if s.Tags != nil {
+		tw.writeFieldBegin(thriftList, 10)
+		encodeJaegerTags(tw, s.Tags)
+	}
+	if s.Logs != nil {
+		tw.writeFieldBegin(thriftList, 11)
+		tw.writeListBegin(thriftStruct, len(s.Logs))
+		for i := range s.Logs {
+			encodeJaegerLog(tw, &s.Logs[i])
+		}
+	}
+	tw.writeFieldStop()
+}
+
+func encodeJaegerSpanRef(tw *thriftWriter, ref *model.JaegerSpanRef) {
+	tw.writeFieldBegin(thriftI32, 1)
+	tw.writeI32(int32(ref.RefType))
+	tw.writeFieldBegin(thriftI64, 2)
+	tw.writeI64(ref.TraceIDLow)
+	tw.writeFieldBegin(thriftI64, 3)
+	tw.writeI64(ref.TraceIDHigh)
+	tw.writeFieldBegin(thriftI64, 4)
+	tw.writeI64(ref.SpanID)
+	tw.writeFieldStop()
+}
+
+func encodeJaegerLog(tw *thriftWriter, l *model.JaegerLog) {
+	tw.writeFieldBegin(thriftI64, 1)
+	tw.writeI64(l.Timestamp)
+	tw.writeFieldBegin(thriftList, 2)
+	encodeJaegerTags(tw, l.Fields)
+	tw.writeFieldStop()
+}
+
+func encodeJaegerTag(tw *thriftWriter, tag *model.JaegerTag) {
+	tw.writeFieldBegin(thriftString, 1)
+	tw.writeString(tag.Key)
+	tw.writeFieldBegin(thriftI32, 2)
+	tw.writeI32(int32(tag.VType))
+	switch tag.VType {
+	case model.JaegerTagDouble:
+		tw.writeFieldBegin(thriftDouble, 4)
+		tw.writeDouble(tag.VDouble)
+	case model.JaegerTagBool:
+		tw.writeFieldBegin(thriftBool, 5)
+		tw.writeBool(tag.VBool)
+	case model.JaegerTagLong:
+		tw.writeFieldBegin(thriftI64, 6)
+		tw.writeI64(tag.VLong)
+	case model.JaegerTagBinary:
+		tw.writeFieldBegin(thriftString, 7)
+		tw.writeBinary(tag.VBinary)
+	default:
+		tw.writeFieldBegin(thriftString, 3)
+		tw.writeString(tag.VStr)
+	}
+	tw.writeFieldStop()
+}
+
+func encodeJaegerTags(tw *thriftWriter, tags []model.JaegerTag) {
+	tw.writeListBegin(thriftStruct, len(tags))
+	for i := range tags {
+		encodeJaegerTag(tw, &tags[i])
+	}
+}
+
+// thriftWriter writes values with the Thrift binary protocol. Errors are
+// sticky: once a write failed, the following ones are no-ops and err holds
+// the first error.
+type thriftWriter struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func newThriftWriter(w io.Writer) *thriftWriter {
+	return &thriftWriter{w: w}
+}
+
+func (tw *thriftWriter) write(b []byte) {
+	if tw.err == nil {
+		_, tw.err = tw.w.Write(b)
+	}
+}
+
+func (tw *thriftWriter) writeByte(v byte) {
+	tw.buf[0] = v
+	tw.write(tw.buf[:1])
+}
+
+func (tw *thriftWriter) writeBool(v bool) {
+	if v {
+		tw.writeByte(1)
+	} else {
+		tw.writeByte(0)
+	}
+}
+
+func (tw *thriftWriter) writeI16(v int16) {
+	binary.BigEndian.PutUint16(tw.buf[:2], uint16(v))
+	tw.write(tw.buf[:2])
+}
+
+func (tw *thriftWriter) writeI32(v int32) {
+	binary.BigEndian.PutUint32(tw.buf[:4], uint32(v))
+	tw.write(tw.buf[:4])
+}
+
+func (tw *thriftWriter) writeI64(v int64) {
+	binary.BigEndian.PutUint64(tw.buf[:8], uint64(v))
+	tw.write(tw.buf[:8])
+}
+
+func (tw *thriftWriter) writeDouble(v float64) {
+	tw.writeI64(int64(math.Float64bits(v)))
+}
+
+func (tw *thriftWriter) writeBinary(v []byte) {
+	tw.writeI32(int32(len(v)))
+	tw.write(v)
+}
+
+func (tw *thriftWriter) writeString(v string) {
+	tw.writeBinary([]byte(v))
+}
+
+func (tw *thriftWriter) writeFieldBegin(t byte, id int16) {
+	tw.writeByte(t)
+	tw.writeI16(id)
+}
+
+func (tw *thriftWriter) writeFieldStop() {
+	tw.writeByte(thriftStop)
+}
+
+func (tw *thriftWriter) writeListBegin(elemType byte, n int) {
+	tw.writeByte(elemType)
+	tw.writeI32(int32(n))
+}
@@ -0,0 +1,51 @@
+package fixtures
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func TestEncodeJaegerBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	batch := &model.JaegerBatch{
+		Process: model.JaegerProcess{
+			ServiceName: "frontend",
+			Tags:        []model.JaegerTag{{Key: "hostname", VType: model.JaegerTagString, VStr: "host-1"}},
+		},
+		Spans: []model.JaegerSpan{
+			{
+				TraceIDLow:    42,
+				TraceIDHigh:   7,
+				SpanID:        2,
+				ParentSpanID:  1,
+				OperationName: "query",
+				References:    []model.JaegerSpanRef{{RefType: model.JaegerChildOf, TraceIDLow: 42, SpanID: 1}},
+				Flags:         1,
+				StartTime:     1502787600000200,
+				Duration:      300,
+				Tags: []model.JaegerTag{
+					{Key: "db.rows", VType: model.JaegerTagDouble, VDouble: 12.5},
+					{Key: "db.cached", VType: model.JaegerTagBool, VBool: true},
+					{Key: "db.shard", VType: model.JaegerTagLong, VLong: 3},
+					{Key: "db.key", VType: model.JaegerTagBinary, VBinary: []byte{0xca, 0xfe}},
+				},
+				Logs: []model.JaegerLog{
+					{Timestamp: 1502787600000300, Fields: []model.JaegerTag{
+						{Key: "event", VType: model.JaegerTagString, VStr: "error"},
+					}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(EncodeJaegerBatch(&buf, batch))
+	decoded, err := model.DecodeJaegerBatch(&buf)
+	assert.Nil(err)
+	assert.Equal(batch, decoded)
+}
@@ -0,0 +1,72 @@
+package model
+
+// Apdex measures, counting the top-level spans by how their duration compares
+// to the Apdex threshold T of their service or resource. The score is then
+// (satisfied + tolerating/2) / (satisfied + tolerating + frustrated).
+const (
+	ApdexSatisfied  = "apdex.satisfied"  // duration <= T
+	ApdexTolerating = "apdex.tolerating" // T < duration <= 4T
+	ApdexFrustrated = "apdex.frustrated" // duration > 4T, or error
+)
+
+type serviceResource struct {
+	service  string
+	resource string
+}
+
+// ApdexThresholds holds the Apdex thresholds, in nanoseconds, of services and
+// resources. It must not be modified once used by a StatsRawBucket.
+type ApdexThresholds struct {
+	def       int64
+	services  map[string]int64
+	resources map[serviceResource]int64
+}
+
+// NewApdexThresholds returns Apdex thresholds defaulting to def nanoseconds
+func NewApdexThresholds(def int64) *ApdexThresholds {
+	return &ApdexThresholds{
+		def:       def,
+		services:  make(map[string]int64),
+		resources: make(map[serviceResource]int64),
+	}
+}
+
+// Set overrides the threshold of a service, or of one of its resources if
+// resource is not empty. Resources are the quantized ones, as shown in the app.
+func (at *ApdexThresholds) Set(service, resource string, threshold int64) {
+	if resource == "" {
+		at.services[service] = threshold
+		return
+	}
+	at.resources[serviceResource{service, resource}] = threshold
+}
+
+// Get returns the threshold of a resource of a service, 0 meaning no Apdex
+// is computed for it.
+func (at *ApdexThresholds) Get(service, resource string) int64 {
+	if t, ok := at.resources[serviceResource{service, resource}]; ok {
+		return t
+	}
+	if t, ok := at.services[service]; ok {
+		return t
+	}
+	return at.def
+}
+
+// apdexMeasure returns the Apdex measure s counts for, or "" if none
+func (at *ApdexThresholds) apdexMeasure(s *Span) string {
+	if at == nil || !s.topLevel {
+		return ""
+	}
+	threshold := at.Get(s.Service, s.Resource)
+	switch {
+	case threshold <= 0:
+		return ""
+	case s.Error != 0 || s.Duration > 4*threshold:
+		return ApdexFrustrated
+	case s.Duration > threshold:
+		return ApdexTolerating
+	default:
+		return ApdexSatisfied
+	}
+}
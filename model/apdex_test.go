@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApdexThresholds(t *testing.T) {
+	assert := assert.New(t)
+
+	at := NewApdexThresholds(500)
+	at.Set("web", "", 200)
+	at.Set("web", "POST /checkout", 2000)
+
+	assert.Equal(int64(500), at.Get("db", "SELECT"))
+	assert.Equal(int64(200), at.Get("web", "GET /"))
+	assert.Equal(int64(2000), at.Get("web", "POST /checkout"))
+	assert.Equal(int64(500), at.Get("api", "POST /checkout"))
+}
+
+func TestApdexMeasure(t *testing.T) {
+	assert := assert.New(t)
+
+	at := NewApdexThresholds(100)
+	at.Set("batch", "", 0)
+
+	for _, tc := range []struct {
+		span     Span
+		expected string
+	}{
+		{Span{Service: "web", Duration: 100, topLevel: true}, ApdexSatisfied},
+		{Span{Service: "web", Duration: 101, topLevel: true}, ApdexTolerating},
+		{Span{Service: "web", Duration: 400, topLevel: true}, ApdexTolerating},
+		{Span{Service: "web", Duration: 401, topLevel: true}, ApdexFrustrated},
+		{Span{Service: "web", Duration: 10, Error: 1, topLevel: true}, ApdexFrustrated},
+		// only top-level spans of services with a threshold are counted
+		{Span{Service: "web", Duration: 10}, ""},
+		{Span{Service: "batch", Duration: 10, topLevel: true}, ""},
+	} {
+		assert.Equal(tc.expected, at.apdexMeasure(&tc.span), "%v", tc.span)
+	}
+
+	var none *ApdexThresholds
+	assert.Equal("", none.apdexMeasure(&Span{Duration: 10, topLevel: true}))
+}
@@ -0,0 +1,87 @@
+package model
+
+// ClockSkewMetricKey is the metric key holding the offset, in nanoseconds,
+// added to the start of a span to correct the clock skew of its host.
+const ClockSkewMetricKey = "_dd.clock_skew"
+
+// CorrectClockSkew shifts the spans of a distributed trace whose clock is
+// skewed relative to their parent's. Only the children of a span from another
+// service, likely running on another host, are checked: if such a child starts
+// before its parent, or ends after it while overlapping it, it is moved back in
+// its window along with all its descendants, assuming network latency is the
+// same both ways. Children starting after their parent ended, such as
+// asynchronous consumers, are left as they are.
+func (t Trace) CorrectClockSkew() {
+	if len(t) < 2 {
+		return
+	}
+
+	spanIDToIdx := t.spanIDToIdx()
+	children := make(map[int][]int, len(t))
+	var roots []int
+	for i := range t {
+		if parentIdx, ok := spanIDToIdx[t[i].ParentID]; ok && t[i].ParentID != 0 && parentIdx != i {
+			children[parentIdx] = append(children[parentIdx], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	type shift struct {
+		idx    int
+		offset int64 // offset of the parent
+	}
+	visited := make([]bool, len(t))
+	stack := make([]shift, 0, len(t))
+	for _, r := range roots {
+		stack = append(stack, shift{r, 0})
+	}
+	for len(stack) > 0 {
+		sh := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[sh.idx] {
+			continue
+		}
+		visited[sh.idx] = true
+
+		if sh.offset != 0 {
+			t[sh.idx].shiftStart(sh.offset)
+		}
+		for _, c := range children[sh.idx] {
+			offset := sh.offset
+			if t[c].Service != t[sh.idx].Service {
+				offset += clockSkew(&t[sh.idx], &t[c], sh.offset)
+			}
+			stack = append(stack, shift{c, offset})
+		}
+	}
+}
+
+// clockSkew returns the offset to add to the start of child, once shifted by
+// offset like its parent already was, to move it in the window of parent.
+func clockSkew(parent, child *Span, offset int64) int64 {
+	start := child.Start + offset
+	end := start + child.Duration
+	if start >= parent.Start && (end <= parent.End() || start >= parent.End()) {
+		// within its parent, or asynchronous
+		return 0
+	}
+	if child.Duration > parent.Duration {
+		// cannot fit, such as an asynchronous call: only make sure
+		// it does not start before its parent
+		if start < parent.Start {
+			return parent.Start - start
+		}
+		return 0
+	}
+	// center the child in its parent
+	return parent.Start + (parent.Duration-child.Duration)/2 - start
+}
+
+func (s *Span) shiftStart(offset int64) {
+	s.Start += offset
+	if s.Metrics == nil {
+		s.Metrics = make(map[string]float64, 1)
+	}
+	s.Metrics[ClockSkewMetricKey] = float64(offset)
+}
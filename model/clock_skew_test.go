@@ -0,0 +1,112 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrectClockSkew(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		{SpanID: 1, Service: "web", Start: 1000, Duration: 100},
+		// same service, never shifted even if outside its parent
+		{SpanID: 2, ParentID: 1, Service: "web", Start: 1090, Duration: 20},
+		// another service, starts 50 before its parent: centered in it
+		{SpanID: 3, ParentID: 1, Service: "db", Start: 950, Duration: 40},
+		// its child is shifted along
+		{SpanID: 4, ParentID: 3, Service: "db", Start: 960, Duration: 10},
+		// another service, within its parent
+		{SpanID: 5, ParentID: 1, Service: "cache", Start: 1010, Duration: 10},
+	}
+	trace.CorrectClockSkew()
+
+	assert.Equal(int64(1000), trace[0].Start)
+	assert.Equal(int64(1090), trace[1].Start)
+	assert.Equal(int64(1030), trace[2].Start)
+	assert.Equal(80.0, trace[2].Metrics[ClockSkewMetricKey])
+	assert.Equal(int64(1040), trace[3].Start)
+	assert.Equal(80.0, trace[3].Metrics[ClockSkewMetricKey])
+	assert.Equal(int64(1010), trace[4].Start)
+	for _, i := range []int{0, 1, 4} {
+		_, ok := trace[i].Metrics[ClockSkewMetricKey]
+		assert.False(ok)
+	}
+}
+
+func TestCorrectClockSkewNested(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		{SpanID: 1, Service: "web", Start: 1000, Duration: 100},
+		// ends 40 after its parent: shifted by -60 to be centered
+		{SpanID: 2, ParentID: 1, Service: "api", Start: 1080, Duration: 60},
+		// fits in its parent once shifted like it
+		{SpanID: 3, ParentID: 2, Service: "db", Start: 1100, Duration: 20},
+		// and gets shifted again if it still does not
+		{SpanID: 4, ParentID: 2, Service: "auth", Start: 1070, Duration: 20},
+	}
+	trace.CorrectClockSkew()
+
+	assert.Equal(int64(1020), trace[1].Start)
+	assert.Equal(int64(1040), trace[2].Start)
+	assert.Equal(-60.0, trace[2].Metrics[ClockSkewMetricKey])
+	assert.Equal(int64(1040), trace[3].Start)
+	assert.Equal(-30.0, trace[3].Metrics[ClockSkewMetricKey])
+}
+
+func TestCorrectClockSkewAsync(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		{SpanID: 1, Service: "web", Start: 1000, Duration: 100},
+		// consumer of a message, starting after its parent ended: not skewed
+		{SpanID: 2, ParentID: 1, Service: "worker", Start: 1500, Duration: 50},
+		{SpanID: 3, ParentID: 2, Service: "db", Start: 1510, Duration: 20},
+		// starts right when its parent ends
+		{SpanID: 4, ParentID: 1, Service: "mailer", Start: 1100, Duration: 20},
+	}
+	trace.CorrectClockSkew()
+
+	assert.Equal(int64(1500), trace[1].Start)
+	assert.Equal(int64(1510), trace[2].Start)
+	assert.Equal(int64(1100), trace[3].Start)
+	for i := range trace {
+		assert.Nil(trace[i].Metrics)
+	}
+}
+
+func TestCorrectClockSkewLongerChild(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		{SpanID: 1, Service: "web", Start: 1000, Duration: 100},
+		// asynchronous, longer than its parent: only moved to not start before it
+		{SpanID: 2, ParentID: 1, Service: "worker", Start: 900, Duration: 500},
+		{SpanID: 3, ParentID: 1, Service: "mailer", Start: 1050, Duration: 500},
+	}
+	trace.CorrectClockSkew()
+
+	assert.Equal(int64(1000), trace[1].Start)
+	assert.Equal(int64(1050), trace[2].Start)
+}
+
+func TestCorrectClockSkewMalformed(t *testing.T) {
+	assert := assert.New(t)
+
+	// cycles and orphans do not prevent the correction of the rest
+	trace := Trace{
+		{SpanID: 1, Service: "web", Start: 1000, Duration: 100},
+		{SpanID: 2, ParentID: 1, Service: "db", Start: 900, Duration: 20},
+		{SpanID: 3, ParentID: 4, Service: "a", Start: 0, Duration: 20},
+		{SpanID: 4, ParentID: 3, Service: "b", Start: 500, Duration: 20},
+		{SpanID: 5, ParentID: 5, Service: "c", Start: 0, Duration: 20},
+	}
+	trace.CorrectClockSkew()
+
+	assert.Equal(int64(1040), trace[1].Start)
+	assert.Equal(int64(0), trace[2].Start)
+	assert.Equal(int64(500), trace[3].Start)
+	assert.Equal(int64(0), trace[4].Start)
+}
@@ -6,9 +6,10 @@ package model
 // clients
 func TracesFromSpans(spans []Span) Traces {
 	traces := Traces{}
-	byID := make(map[uint64][]Span)
+	byID := make(map[TraceKey][]Span)
 	for _, s := range spans {
-		byID[s.TraceID] = append(byID[s.TraceID], s)
+		k := s.TraceKey()
+		byID[k] = append(byID[k], s)
 	}
 	for _, t := range byID {
 		traces = append(traces, t)
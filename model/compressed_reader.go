@@ -0,0 +1,60 @@
+package model
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// UnsupportedEncodingError indicates that a reader was requested for a
+// content encoding we do not know how to decompress.
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+// Error returns an error string
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+// NewCompressedReader returns a reader which transparently decompresses the
+// data read from r, according to the given HTTP Content-Encoding. Supported
+// encodings are "gzip", "deflate" and "identity" (or empty, which means the
+// data is not compressed at all). As per the HTTP spec, "deflate" data is
+// expected in the zlib format, but raw DEFLATE data, which some clients send,
+// is accepted too.
+// Closing the returned reader does not close r.
+func NewCompressedReader(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return ioutil.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return newDeflateReader(r)
+	default:
+		return nil, &UnsupportedEncodingError{Encoding: encoding}
+	}
+}
+
+// newDeflateReader decompresses zlib data, or raw DEFLATE data if r does not
+// start with a zlib header.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if header, err := br.Peek(2); err == nil && isZlibHeader(header[0], header[1]) {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// isZlibHeader tells if cmf and flg are the first bytes of zlib data, as
+// defined by RFC 1950: the DEFLATE compression method, with a window of at
+// most 32K, and a check of both bytes.
+func isZlibHeader(cmf, flg byte) bool {
+	return cmf&0x0f == 8 && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
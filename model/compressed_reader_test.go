@@ -0,0 +1,89 @@
+package model
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedReader(t *testing.T) {
+	assert := assert.New(t)
+	payload := []byte(strings.Repeat("foobar", 100))
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(payload)
+	gz.Close()
+
+	// HTTP deflate is zlib data, such as written by Java's DeflaterOutputStream
+	var deflated bytes.Buffer
+	zl := zlib.NewWriter(&deflated)
+	zl.Write(payload)
+	zl.Close()
+
+	var rawDeflated bytes.Buffer
+	fl, _ := flate.NewWriter(&rawDeflated, flate.BestSpeed)
+	fl.Write(payload)
+	fl.Close()
+
+	testCases := []struct {
+		encoding string
+		data     []byte
+	}{
+		{"", payload},
+		{"identity", payload},
+		{"gzip", gzipped.Bytes()},
+		{"GZIP", gzipped.Bytes()},
+		{"x-gzip", gzipped.Bytes()},
+		{"deflate", deflated.Bytes()},
+		{"deflate", rawDeflated.Bytes()},
+	}
+
+	for _, tc := range testCases {
+		r, err := NewCompressedReader(bytes.NewReader(tc.data), tc.encoding)
+		assert.Nil(err, tc.encoding)
+
+		data, err := ioutil.ReadAll(r)
+		assert.Nil(err, tc.encoding)
+		assert.Equal(payload, data, tc.encoding)
+		assert.Nil(r.Close())
+	}
+}
+
+func TestCompressedReaderErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewCompressedReader(bytes.NewBufferString("foobar"), "br")
+	assert.Equal(&UnsupportedEncodingError{Encoding: "br"}, err)
+
+	// not a gzip payload
+	_, err = NewCompressedReader(bytes.NewBufferString("foobar"), "gzip")
+	assert.NotNil(err)
+}
+
+func TestCompressedReaderLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	// a small payload which expands a lot once decompressed
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(make([]byte, 1024*1024))
+	gz.Close()
+	assert.True(buf.Len() < 10*1024)
+
+	raw := NewLimitedReader(ioutil.NopCloser(&buf), 10*1024)
+	r, err := NewCompressedReader(raw, "gzip")
+	assert.Nil(err)
+
+	lr := NewLimitedReader(r, 1024)
+	_, err = ioutil.ReadAll(lr)
+	assert.Equal(ErrLimitedReaderLimitReached, err)
+	assert.Equal(int64(1024), lr.Count)
+	assert.True(raw.Count > 0)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"errors"
+	"io"
 	"math"
 
 	"github.com/tinylib/msgp/msgp"
@@ -193,3 +194,47 @@ func parseInt32(dc *msgp.Reader) (int32, error) {
 		return 0, msgp.TypeError{Encoded: t, Method: msgp.IntType}
 	}
 }
+
+// TracesDecoder decodes a msgpack encoded list of traces one trace at a time,
+// so that a payload does not have to be held in memory as a whole before its
+// traces can be processed.
+type TracesDecoder struct {
+	dc        *msgp.Reader
+	remaining uint32
+}
+
+// NewTracesDecoder reads the header of the list of traces in r, and returns
+// a decoder for its traces.
+func NewTracesDecoder(r io.Reader) (*TracesDecoder, error) {
+	dc := msgp.NewReader(r)
+	n, err := dc.ReadArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+	return &TracesDecoder{dc: dc, remaining: n}, nil
+}
+
+// Len returns the number of traces left to decode
+func (d *TracesDecoder) Len() int {
+	return int(d.remaining)
+}
+
+// Next decodes the next trace, returning io.EOF once they have all been
+// decoded. Spans are decoded with the same leniency as Span.DecodeMsg, e.g.
+// accepting signed integers for unsigned fields.
+func (d *TracesDecoder) Next() (Trace, error) {
+	if d.remaining == 0 {
+		return nil, io.EOF
+	}
+	var t Trace
+	if err := t.DecodeMsg(d.dc); err != nil {
+		// the rest of the payload cannot be trusted
+		d.remaining = 0
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.remaining--
+	return t, nil
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"bytes"
+	"io"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,3 +41,73 @@ func TestParseFloat64(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(3.14, f)
 }
+
+func TestTracesDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	traces := Traces{
+		Trace{{TraceID: 1, SpanID: 1, Service: "mcnulty", Name: "query"}},
+		Trace{{TraceID: 2, SpanID: 2}, {TraceID: 2, SpanID: 3, ParentID: 2}},
+	}
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, traces))
+
+	dec, err := NewTracesDecoder(&buf)
+	assert.Nil(err)
+	assert.Equal(2, dec.Len())
+	for _, expected := range traces {
+		trace, err := dec.Next()
+		assert.Nil(err)
+		assert.Equal(expected, trace)
+	}
+	assert.Equal(0, dec.Len())
+	_, err = dec.Next()
+	assert.Equal(io.EOF, err)
+}
+
+func TestTracesDecoderLenient(t *testing.T) {
+	assert := assert.New(t)
+
+	// IDs sent as signed integers, durations as unsigned ones
+	data := msgp.AppendArrayHeader(nil, 1)
+	data = msgp.AppendArrayHeader(data, 1)
+	data = msgp.AppendMapHeader(data, 3)
+	data = msgp.AppendString(data, "trace_id")
+	data = msgp.AppendInt64(data, 42)
+	data = msgp.AppendString(data, "span_id")
+	data = msgp.AppendInt64(data, -1)
+	data = msgp.AppendString(data, "duration")
+	data = msgp.AppendUint64(data, 1000)
+
+	dec, err := NewTracesDecoder(bytes.NewReader(data))
+	assert.Nil(err)
+	trace, err := dec.Next()
+	assert.Nil(err)
+	assert.Equal(Trace{{TraceID: 42, SpanID: math.MaxUint64, Duration: 1000}}, trace)
+}
+
+func TestTracesDecoderTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	traces := Traces{
+		Trace{{TraceID: 1, SpanID: 1}},
+		Trace{{TraceID: 2, SpanID: 2}},
+	}
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, traces))
+	data := buf.Bytes()
+
+	dec, err := NewTracesDecoder(bytes.NewReader(data[:len(data)-4]))
+	assert.Nil(err)
+	trace, err := dec.Next()
+	assert.Nil(err)
+	assert.Equal(traces[0], trace)
+	_, err = dec.Next()
+	assert.Equal(io.ErrUnexpectedEOF, err)
+	// and it stops there
+	_, err = dec.Next()
+	assert.Equal(io.EOF, err)
+
+	_, err = NewTracesDecoder(bytes.NewReader(nil))
+	assert.NotNil(err)
+}
@@ -0,0 +1,359 @@
+package model
+
+import (
+	"encoding/hex"
+	"io"
+	"strconv"
+)
+
+// JaegerTagType is the type of the value of a Jaeger tag
+type JaegerTagType int32
+
+// Jaeger tag types, see jaeger-idl/thrift/jaeger.thrift
+const (
+	JaegerTagString JaegerTagType = iota
+	JaegerTagDouble
+	JaegerTagBool
+	JaegerTagLong
+	JaegerTagBinary
+)
+
+// JaegerTag is a typed key/value pair attached to spans, logs and processes
+type JaegerTag struct {
+	Key     string
+	VType   JaegerTagType
+	VStr    string
+	VDouble float64
+	VBool   bool
+	VLong   int64
+	VBinary []byte
+}
+
+// Value returns the value of the tag, formatted as a string
+func (t *JaegerTag) Value() string {
+	switch t.VType {
+	case JaegerTagDouble:
+		return strconv.FormatFloat(t.VDouble, 'f', -1, 64)
+	case JaegerTagBool:
+		return strconv.FormatBool(t.VBool)
+	case JaegerTagLong:
+		return strconv.FormatInt(t.VLong, 10)
+	case JaegerTagBinary:
+		return hex.EncodeToString(t.VBinary)
+	default:
+		return t.VStr
+	}
+}
+
+// JaegerLog is a set of fields logged at a given time during a span
+type JaegerLog struct {
+	Timestamp int64 // microsecond epoch
+	Fields    []JaegerTag
+}
+
+// JaegerSpanRefType is the causal relationship a Jaeger span has with another one
+type JaegerSpanRefType int32
+
+// Jaeger span reference types
+const (
+	JaegerChildOf JaegerSpanRefType = iota
+	JaegerFollowsFrom
+)
+
+// JaegerSpanRef is a reference from a Jaeger span to another span
+type JaegerSpanRef struct {
+	RefType     JaegerSpanRefType
+	TraceIDLow  int64
+	TraceIDHigh int64
+	SpanID      int64
+}
+
+// JaegerSpan is a span as sent by Jaeger clients
+type JaegerSpan struct {
+	TraceIDLow    int64
+	TraceIDHigh   int64
+	SpanID        int64
+	ParentSpanID  int64
+	OperationName string
+	References    []JaegerSpanRef
+	Flags         int32
+	StartTime     int64 // microsecond epoch
+	Duration      int64 // in microseconds
+	Tags          []JaegerTag
+	Logs          []JaegerLog
+}
+
+// JaegerProcess describes the traced process which emitted a batch of spans
+type JaegerProcess struct {
+	ServiceName string
+	Tags        []JaegerTag
+}
+
+// JaegerBatch is the payload Jaeger clients send, a list of spans emitted by a process
+type JaegerBatch struct {
+	Process JaegerProcess
+	Spans   []JaegerSpan
+}
+
+const (
+	// jaegerFlagDebug is set on the spans of traces which must be kept
+	jaegerFlagDebug = 2
+	// jaegerErrorTag is the tag Jaeger clients, like all OpenTracing ones, use to flag errors
+	jaegerErrorTag = "error"
+)
+
+// DecodeJaegerBatch decodes a Batch encoded with the Thrift binary protocol,
+// which is what jaeger-client HTTPSender sends.
+func DecodeJaegerBatch(r io.Reader) (*JaegerBatch, error) {
+	var b JaegerBatch
+	if err := b.decode(newThriftReader(r)); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (b *JaegerBatch) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) error {
+		switch {
+		case id == 1 && t == thriftStruct:
+			return b.Process.decode(tr)
+		case id == 2 && t == thriftList:
+			return tr.readList(thriftStruct, func() error {
+				var s JaegerSpan
+				err := s.decode(tr)
+				b.Spans = append(b.Spans, s)
+				return err
+			})
+		default:
+			return tr.skip(t)
+		}
+	})
+}
+
+func (p *JaegerProcess) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) (err error) {
+		switch {
+		case id == 1 && t == thriftString:
+			p.ServiceName, err = tr.readString()
+		case id == 2 && t == thriftList:
+			p.Tags, err = decodeJaegerTags(tr)
+		default:
+			err = tr.skip(t)
+		}
+		return err
+	})
+}
+
+func (s *JaegerSpan) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) (err error) {
+		switch {
+		case id == 1 && t == thriftI64:
+			s.TraceIDLow, err = tr.readI64()
+		case id == 2 && t == thriftI64:
+			s.TraceIDHigh, err = tr.readI64()
+		case id == 3 && t == thriftI64:
+			s.SpanID, err = tr.readI64()
+		case id == 4 && t == thriftI64:
+			s.ParentSpanID, err = tr.readI64()
+		case id == 5 && t == thriftString:
+			s.OperationName, err = tr.readString()
+		case id == 6 && t == thriftList:
+			err = tr.readList(thriftStruct, func() error {
+				var ref JaegerSpanRef
+				err := ref.decode(tr)
+				s.References = append(s.References, ref)
+				return err
+			})
+		case id == 7 && t == thriftI32:
+			s.Flags, err = tr.readI32()
+		case id == 8 && t == thriftI64:
+			s.StartTime, err = tr.readI64()
+		case id == 9 && t == thriftI64:
+			s.Duration, err = tr.readI64()
+		case id == 10 && t == thriftList:
+			s.Tags, err = decodeJaegerTags(tr)
+		case id == 11 && t == thriftList:
+			err = tr.readList(thriftStruct, func() error {
+				var l JaegerLog
+				err := l.decode(tr)
+				s.Logs = append(s.Logs, l)
+				return err
+			})
+		default:
+			err = tr.skip(t)
+		}
+		return err
+	})
+}
+
+func (ref *JaegerSpanRef) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) (err error) {
+		switch {
+		case id == 1 && t == thriftI32:
+			var v int32
+			v, err = tr.readI32()
+			ref.RefType = JaegerSpanRefType(v)
+		case id == 2 && t == thriftI64:
+			ref.TraceIDLow, err = tr.readI64()
+		case id == 3 && t == thriftI64:
+			ref.TraceIDHigh, err = tr.readI64()
+		case id == 4 && t == thriftI64:
+			ref.SpanID, err = tr.readI64()
+		default:
+			err = tr.skip(t)
+		}
+		return err
+	})
+}
+
+func (l *JaegerLog) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) (err error) {
+		switch {
+		case id == 1 && t == thriftI64:
+			l.Timestamp, err = tr.readI64()
+		case id == 2 && t == thriftList:
+			l.Fields, err = decodeJaegerTags(tr)
+		default:
+			err = tr.skip(t)
+		}
+		return err
+	})
+}
+
+func (tag *JaegerTag) decode(tr *thriftReader) error {
+	return tr.readStruct(func(t byte, id int16) (err error) {
+		switch {
+		case id == 1 && t == thriftString:
+			tag.Key, err = tr.readString()
+		case id == 2 && t == thriftI32:
+			var v int32
+			v, err = tr.readI32()
+			tag.VType = JaegerTagType(v)
+		case id == 3 && t == thriftString:
+			tag.VStr, err = tr.readString()
+		case id == 4 && t == thriftDouble:
+			tag.VDouble, err = tr.readDouble()
+		case id == 5 && t == thriftBool:
+			tag.VBool, err = tr.readBool()
+		case id == 6 && t == thriftI64:
+			tag.VLong, err = tr.readI64()
+		case id == 7 && t == thriftString:
+			tag.VBinary, err = tr.readBinary()
+		default:
+			err = tr.skip(t)
+		}
+		return err
+	})
+}
+
+func decodeJaegerTags(tr *thriftReader) ([]JaegerTag, error) {
+	var tags []JaegerTag
+	err := tr.readList(thriftStruct, func() error {
+		var tag JaegerTag
+		err := tag.decode(tr)
+		tags = append(tags, tag)
+		return err
+	})
+	return tags, err
+}
+
+// ToSpan converts a Jaeger span emitted by the given process to our own
+// model. The resulting span still has to be normalized.
+func (js *JaegerSpan) ToSpan(process *JaegerProcess) Span {
+	s := Span{
+		Service:     process.ServiceName,
+		Name:        js.OperationName,
+		Resource:    js.OperationName,
+		TraceID:     uint64(js.TraceIDLow),
+		TraceIDHigh: uint64(js.TraceIDHigh),
+		SpanID:      uint64(js.SpanID),
+		ParentID:    uint64(js.ParentSpanID),
+		Start:       js.StartTime * 1000,
+		Duration:    js.Duration * 1000,
+		Meta:        make(map[string]string, len(process.Tags)+len(js.Tags)),
+	}
+
+	// the span tags have precedence over the process ones
+	for i := range process.Tags {
+		s.Meta[process.Tags[i].Key] = process.Tags[i].Value()
+	}
+	for i := range js.Tags {
+		tag := &js.Tags[i]
+		if tag.Key == jaegerErrorTag {
+			if tag.Value() == "true" {
+				s.Error = 1
+			}
+			continue
+		}
+		s.Meta[tag.Key] = tag.Value()
+	}
+
+	// recent clients set the parent as a reference rather than in ParentSpanID
+	if s.ParentID == 0 {
+		for _, ref := range js.References {
+			if ref.RefType == JaegerChildOf {
+				s.ParentID = uint64(ref.SpanID)
+				break
+			}
+		}
+	}
+	if s.ParentID == 0 {
+		for _, ref := range js.References {
+			if ref.RefType == JaegerFollowsFrom {
+				s.ParentID = uint64(ref.SpanID)
+				s.Meta["span.follows_from"] = strconv.FormatUint(s.ParentID, 10)
+				break
+			}
+		}
+	}
+
+	// OpenTracing clients log the details of errors as an "error" event. We
+	// have no events: the other logs are dropped, as there is no bounded way
+	// to keep them in the meta, a span logging any number of them.
+	for _, l := range js.Logs {
+		fields := make(map[string]string, len(l.Fields))
+		for i := range l.Fields {
+			fields[l.Fields[i].Key] = l.Fields[i].Value()
+		}
+		if fields["event"] != jaegerErrorTag {
+			continue
+		}
+		s.Error = 1
+		if msg := fields["message"]; msg != "" {
+			s.Meta["error.msg"] = msg
+		} else if msg := fields["error.object"]; msg != "" {
+			s.Meta["error.msg"] = msg
+		}
+		if kind := fields["error.kind"]; kind != "" {
+			s.Meta["error.type"] = kind
+		}
+		if stack := fields["stack"]; stack != "" {
+			s.Meta["error.stack"] = stack
+		}
+	}
+
+	switch s.Meta["span.kind"] {
+	case "server":
+		s.Type = "web"
+	case "client":
+		if s.Meta["http.method"] != "" {
+			s.Type = "http"
+		}
+	}
+
+	if js.Flags&jaegerFlagDebug != 0 {
+		s.Metrics = map[string]float64{SamplingPriorityKey: debugSamplingPriority}
+	}
+
+	return s
+}
+
+// TracesFromJaegerBatch converts the spans of a Jaeger batch to our model and
+// groups them by trace.
+func TracesFromJaegerBatch(b *JaegerBatch) Traces {
+	spans := make([]Span, len(b.Spans))
+	for i := range b.Spans {
+		spans[i] = b.Spans[i].ToSpan(&b.Process)
+	}
+	return TracesFromSpans(spans)
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testJaegerBatch() *JaegerBatch {
+	return &JaegerBatch{
+		Process: JaegerProcess{
+			ServiceName: "frontend",
+			Tags: []JaegerTag{
+				{Key: "hostname", VType: JaegerTagString, VStr: "host-1"},
+				{Key: "jaeger.version", VType: JaegerTagString, VStr: "Go-2.11.2"},
+			},
+		},
+		Spans: []JaegerSpan{
+			{
+				TraceIDLow:    42,
+				SpanID:        1,
+				OperationName: "HTTP GET",
+				Flags:         1 | jaegerFlagDebug,
+				StartTime:     1502787600000000,
+				Duration:      1500,
+				Tags: []JaegerTag{
+					{Key: "span.kind", VType: JaegerTagString, VStr: "server"},
+					{Key: "http.status_code", VType: JaegerTagLong, VLong: 500},
+					{Key: "error", VType: JaegerTagBool, VBool: true},
+					{Key: "hostname", VType: JaegerTagString, VStr: "host-2"},
+				},
+				Logs: []JaegerLog{
+					{Timestamp: 1502787600000100, Fields: []JaegerTag{
+						{Key: "event", VType: JaegerTagString, VStr: "error"},
+						{Key: "message", VType: JaegerTagString, VStr: "boom"},
+						{Key: "error.kind", VType: JaegerTagString, VStr: "panic"},
+					}},
+				},
+			},
+			{
+				TraceIDLow:    42,
+				SpanID:        2,
+				OperationName: "query",
+				References:    []JaegerSpanRef{{RefType: JaegerChildOf, TraceIDLow: 42, SpanID: 1}},
+				StartTime:     1502787600000200,
+				Duration:      300,
+				Tags: []JaegerTag{
+					{Key: "db.rows", VType: JaegerTagDouble, VDouble: 12.5},
+					{Key: "db.key", VType: JaegerTagBinary, VBinary: []byte{0xca, 0xfe}},
+				},
+				Logs: []JaegerLog{
+					{Timestamp: 1502787600000300, Fields: []JaegerTag{
+						{Key: "event", VType: JaegerTagString, VStr: "cache miss"},
+						{Key: "retries", VType: JaegerTagLong, VLong: 2},
+					}},
+				},
+			},
+			{
+				TraceIDLow:    42,
+				SpanID:        3,
+				OperationName: "send_mail",
+				References:    []JaegerSpanRef{{RefType: JaegerFollowsFrom, TraceIDLow: 42, SpanID: 2}},
+				StartTime:     1502787600000600,
+				Duration:      100,
+			},
+		},
+	}
+}
+
+func TestJaegerBatchDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	// a process with its service name, and a span whose unknown fields are skipped
+	data := []byte{
+		thriftStruct, 0, 1, // process
+		thriftString, 0, 1, 0, 0, 0, 8, 'f', 'r', 'o', 'n', 't', 'e', 'n', 'd',
+		thriftStop,
+		thriftList, 0, 2, thriftStruct, 0, 0, 0, 1, // spans
+		thriftI64, 0, 3, 0, 0, 0, 0, 0, 0, 0, 7, // span ID
+		thriftList, 0, 42, thriftI32, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2, // unknown
+		thriftStop,
+		thriftStop,
+	}
+	batch, err := DecodeJaegerBatch(bytes.NewReader(data))
+	assert.Nil(err)
+	assert.Equal(&JaegerBatch{
+		Process: JaegerProcess{ServiceName: "frontend"},
+		Spans:   []JaegerSpan{{SpanID: 7}},
+	}, batch)
+
+	// truncated payload
+	_, err = DecodeJaegerBatch(bytes.NewReader(data[:len(data)/2]))
+	assert.NotNil(err)
+
+	// bogus length, we should not try to allocate 2GB
+	_, err = DecodeJaegerBatch(bytes.NewReader([]byte{thriftStruct, 0, 1, thriftString, 0, 1, 0x7f, 0xff, 0xff, 0xff}))
+	assert.NotNil(err)
+}
+
+func TestJaegerSpanToSpanTraceIDHigh(t *testing.T) {
+	batch := testJaegerBatch()
+	js := batch.Spans[0]
+	js.TraceIDHigh = 7
+
+	s := js.ToSpan(&batch.Process)
+	assert.Equal(t, TraceKey{High: 7, Low: 42}, s.TraceKey())
+}
+
+func TestJaegerSpanToSpan(t *testing.T) {
+	assert := assert.New(t)
+
+	batch := testJaegerBatch()
+
+	root := batch.Spans[0].ToSpan(&batch.Process)
+	assert.Equal("frontend", root.Service)
+	assert.Equal("HTTP GET", root.Name)
+	assert.Equal("HTTP GET", root.Resource)
+	assert.Equal(uint64(42), root.TraceID)
+	assert.Equal(uint64(0), root.TraceIDHigh)
+	assert.Equal(uint64(1), root.SpanID)
+	assert.Equal(uint64(0), root.ParentID)
+	assert.Equal(int64(1502787600000000000), root.Start)
+	assert.Equal(int64(1500000), root.Duration)
+	assert.Equal(int32(1), root.Error)
+	assert.Equal("web", root.Type)
+	assert.Equal(map[string]string{
+		"hostname":         "host-2",
+		"jaeger.version":   "Go-2.11.2",
+		"span.kind":        "server",
+		"http.status_code": "500",
+		"error.msg":        "boom",
+		"error.type":       "panic",
+	}, root.Meta)
+	assert.Equal(float64(debugSamplingPriority), root.Metrics[SamplingPriorityKey])
+
+	child := batch.Spans[1].ToSpan(&batch.Process)
+	assert.Equal(uint64(1), child.ParentID)
+	assert.Equal(int32(0), child.Error)
+	assert.Equal("12.5", child.Meta["db.rows"])
+	assert.Equal("cafe", child.Meta["db.key"])
+	// logs other than errors are dropped
+	assert.Len(child.Meta, 4)
+	assert.Nil(child.Metrics)
+
+	follower := batch.Spans[2].ToSpan(&batch.Process)
+	assert.Equal(uint64(2), follower.ParentID)
+	assert.Equal("2", follower.Meta["span.follows_from"])
+
+	traces := TracesFromJaegerBatch(batch)
+	assert.Len(traces, 1)
+	trace, err := NormalizeTrace(traces[0])
+	assert.Nil(err)
+	assert.Len(trace, 3)
+	assert.Equal(uint64(1), trace.GetRoot().SpanID)
+}
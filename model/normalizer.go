@@ -74,7 +74,7 @@ func (s *Span) Normalize() error {
 
 	// TraceID & SpanID should be set in the client
 	// because they uniquely define the traces and associate them into traces
-	if s.TraceID == 0 {
+	if s.TraceID == 0 && s.TraceIDHigh == 0 {
 		return errors.New("span.normalize: empty `TraceID`")
 	}
 	if s.SpanID == 0 {
@@ -179,16 +179,16 @@ func NormalizeTrace(t Trace) (Trace, error) {
 
 	spanIDs := make(map[uint64]struct{})
 
-	traceID := t[0].TraceID
+	traceID := t[0].TraceKey()
 	for i, span := range t {
 		if _, ok := spanIDs[span.SpanID]; ok {
 			return t, fmt.Errorf("duplicate span id %v (span %v)",
 				span.SpanID, span)
 		}
 
-		if span.TraceID != traceID {
-			return t, fmt.Errorf("trace id mismatch %s:%x != %s:%x",
-				t[0].Name, t[0].TraceID, span.Name, span.TraceID)
+		if span.TraceKey() != traceID {
+			return t, fmt.Errorf("trace id mismatch %s:%s != %s:%s",
+				t[0].Name, traceID, span.Name, span.TraceKey())
 		}
 
 		if err := t[i].Normalize(); err != nil {
@@ -220,6 +220,12 @@ func isAlphaNum(b byte) bool {
 	return isAlpha(b) || (b >= '0' && b <= '9')
 }
 
+// NormalizeName normalizes a span name the way Normalize does, telling if it
+// is valid at all
+func NormalizeName(name string) (string, bool) {
+	return normMetricNameParse(name)
+}
+
 // normMetricNameParse normalizes metric names with a parser instead of using
 // garbage-creating string replacement routines.
 func normMetricNameParse(name string) (string, bool) {
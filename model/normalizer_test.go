@@ -270,6 +270,29 @@ func TestNormalizeTraceTraceIdMismatch(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNormalizeTraceTraceIDHighMismatch(t *testing.T) {
+	span1 := testSpan()
+	span1.TraceIDHigh = 1
+
+	span2 := testSpan()
+	span2.SpanID++
+	span2.TraceIDHigh = 2
+
+	_, err := NormalizeTrace(Trace{span1, span2})
+	assert.Error(t, err)
+
+	span2.TraceIDHigh = 1
+	_, err = NormalizeTrace(Trace{span1, span2})
+	assert.NoError(t, err)
+}
+
+func TestNormalizeTraceIDHighOnly(t *testing.T) {
+	s := testSpan()
+	s.TraceID = 0
+	s.TraceIDHigh = 42
+	assert.NoError(t, s.Normalize())
+}
+
 func TestNormalizeTraceInvalidSpan(t *testing.T) {
 	span1 := testSpan()
 
@@ -8,6 +8,13 @@ import (
 const (
 	// SpanSampleRateMetricKey is the metric key holding the sample rate
 	SpanSampleRateMetricKey = "_sample_rate"
+	// SamplingPriorityKey is the metric key holding the sampling priority
+	// decided by the client, on the root span
+	SamplingPriorityKey = "_sampling_priority_v1"
+
+	// debugSamplingPriority is the sampling priority given to the traces
+	// that foreign tracers flag as debug, which must always be kept
+	debugSamplingPriority = 2
 )
 
 // Span is the common struct we use to represent a dapper-like span
@@ -28,12 +35,36 @@ type Span struct {
 	Metrics  map[string]float64 `json:"metrics" msg:"metrics"`     // arbitrary metrics
 	ParentID uint64             `json:"parent_id" msg:"parent_id"` // span ID of the span in which this one was created
 	Type     string             `json:"type" msg:"type"`           // protocol associated with the span
+	// TraceIDHigh holds the upper 64 bits of 128-bit trace IDs, TraceID
+	// holding the lower ones. It is 0 for 64-bit trace IDs.
+	TraceIDHigh uint64 `json:"trace_id_high,omitempty" msg:"trace_id_high"`
 
 	// Those are cached information, they are here not only for optimization,
 	// but because the func which fill their values read
 	// the Metrics map and causes map read/write concurrent accesses.
 	weight   float64 // caches the result of Weight() called on the root span
 	topLevel bool    // caches the result of TopLevel()
+	measured bool    // caches the result of Measured()
+
+	extraMeasures map[string]float64 // caches the metrics aggregated as extra measures
+}
+
+// TraceKey identifies a trace by its full ID, which may be 128-bit
+type TraceKey struct {
+	High, Low uint64
+}
+
+// String formats the key as hexadecimal, the way 128-bit IDs usually are
+func (k TraceKey) String() string {
+	if k.High == 0 {
+		return fmt.Sprintf("%016x", k.Low)
+	}
+	return fmt.Sprintf("%016x%016x", k.High, k.Low)
+}
+
+// TraceKey returns the full ID of the trace of the span
+func (s *Span) TraceKey() TraceKey {
+	return TraceKey{High: s.TraceIDHigh, Low: s.TraceID}
 }
 
 // String formats a Span struct to be displayed as a string
@@ -1,9 +1,12 @@
 package model
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
 )
 
 func testSpan() Span {
@@ -68,3 +71,31 @@ func TestSpanWeightNil(t *testing.T) {
 
 	assert.Equal(1.0, span.Weight(), "Weight should be callable on nil and return a default value")
 }
+
+func TestSpanTraceIDHigh(t *testing.T) {
+	assert := assert.New(t)
+
+	s := testSpan()
+	s.TraceIDHigh = 0x463ac35c9f6413ad
+	assert.Equal(TraceKey{High: 0x463ac35c9f6413ad, Low: 424242}, s.TraceKey())
+	assert.Equal("463ac35c9f6413ad0000000000067932", s.TraceKey().String())
+	assert.Equal("0000000000067932", TraceKey{Low: 424242}.String())
+
+	// msgpack
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, &s))
+	var decoded Span
+	assert.Nil(msgp.Decode(&buf, &decoded))
+	assert.Equal(s, decoded)
+
+	// JSON, the field being left out of 64-bit IDs
+	b, err := json.Marshal(s)
+	assert.Nil(err)
+	decoded = Span{}
+	assert.Nil(json.Unmarshal(b, &decoded))
+	assert.Equal(s, decoded)
+
+	b, err = json.Marshal(testSpan())
+	assert.Nil(err)
+	assert.NotContains(string(b), "trace_id_high")
+}
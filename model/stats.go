@@ -13,11 +13,42 @@ const (
 	DURATION        = "duration"
 )
 
+// Measures breaking down the errors, counted with an extra tag telling their
+// error.type or the class of their http.status_code, e.g. 5xx
+const (
+	ErrorsByType            = "errors.by_type"
+	ErrorsByHTTPStatusClass = "errors.by_http_status_class"
+
+	// ErrorTypeTag and HTTPStatusClassTag are the names of these extra tags
+	ErrorTypeTag       = "error.type"
+	HTTPStatusClassTag = "http.status_class"
+
+	// OtherErrorType is the error type of the errors exceeding the maximum
+	// number of distinct error types counted for a grain
+	OtherErrorType = "_other"
+)
+
+// DefaultMaxErrorTypes is the default maximum number of distinct error types
+// counted for a grain
+const DefaultMaxErrorTypes = 20
+
+// OtherResource is the resource of the grain the spans of a service are
+// aggregated in once the service exceeds its maximum number of grains
+const OtherResource = "_other"
+
+// DefaultMaxGrainsPerService is the default maximum number of distinct grains
+// of a service in a bucket
+const DefaultMaxGrainsPerService = 1000
+
+// MaxCollapsedResources is the number of distinct resources collapsed into
+// OtherResource up to which they are counted, for each service of a bucket
+const MaxCollapsedResources = 10000
+
 var (
 	// DefaultCounts is an array of the measures we represent as Count by default
 	DefaultCounts = [...]string{HITS, ERRORS, DURATION}
 	// DefaultDistributions is an array of the measures we represent as Distribution by default
-	// Not really used right now as we don't have a way to easily add new distros
+	// Extra measures, taken from span metrics, are represented as both
 	DefaultDistributions = [...]string{DURATION}
 )
 
@@ -239,6 +239,8 @@ func TestStatsBucketMany(t *testing.T) {
 	const n = 100000
 
 	srb := NewStatsRawBucket(0, 1e9)
+	// all the resources of the service have their own grain
+	srb.SetMaxGrainsPerService(0)
 
 	// No custom aggregators only the defaults
 	aggr := []string{}
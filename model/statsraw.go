@@ -2,6 +2,7 @@ package model
 
 import (
 	"bytes"
+	"hash/fnv"
 	"sort"
 
 	"github.com/DataDog/datadog-trace-agent/quantile"
@@ -19,6 +20,15 @@ type groupedStats struct {
 	errors               float64
 	duration             float64
 	durationDistribution *quantile.SliceSummary
+
+	// extra measures taken from span metrics, by metric key
+	extraCounts        map[string]float64
+	extraDistributions map[string]*quantile.SliceSummary
+
+	errorsByType            map[string]float64
+	errorsByHTTPStatusClass map[string]float64
+
+	apdex map[string]float64 // by Apdex measure, nil if not computed
 }
 
 type sublayerStats struct {
@@ -62,6 +72,14 @@ type StatsRawBucket struct {
 	start    int64 // timestamp of start in our format
 	duration int64 // duration of a bucket in nanoseconds
 
+	maxErrorTypes int              // maximum number of distinct error types per grain
+	apdex         *ApdexThresholds // if nil, Apdex counts are not computed
+
+	// maximum number of distinct grains per service, unlimited if 0
+	maxGrainsPerService int
+	grains              map[string]int                 // number of grains by service
+	collapsed           map[string]map[uint64]struct{} // hashes of the resources collapsed into OtherResource, by service
+
 	// this should really remain private as it's subject to refactoring
 	data         map[statsKey]groupedStats
 	sublayerData map[statsSubKey]sublayerStats
@@ -74,11 +92,49 @@ type StatsRawBucket struct {
 func NewStatsRawBucket(ts, d int64) *StatsRawBucket {
 	// The only non-initialized value is the Duration which should be set by whoever closes that bucket
 	return &StatsRawBucket{
-		start:        ts,
-		duration:     d,
-		data:         make(map[statsKey]groupedStats),
-		sublayerData: make(map[statsSubKey]sublayerStats),
+		start:         ts,
+		duration:      d,
+		maxErrorTypes: DefaultMaxErrorTypes,
+		data:          make(map[statsKey]groupedStats),
+		sublayerData:  make(map[statsSubKey]sublayerStats),
+
+		maxGrainsPerService: DefaultMaxGrainsPerService,
+		grains:              make(map[string]int),
+		collapsed:           make(map[string]map[uint64]struct{}),
+	}
+}
+
+// SetMaxErrorTypes sets the maximum number of distinct error types counted for
+// a grain, the errors of other types being counted as OtherErrorType.
+func (sb *StatsRawBucket) SetMaxErrorTypes(n int) {
+	sb.maxErrorTypes = n
+}
+
+// SetMaxGrainsPerService sets the maximum number of distinct grains of a
+// service, beyond which its spans are aggregated with the OtherResource
+// resource. There is no limit if 0.
+func (sb *StatsRawBucket) SetMaxGrainsPerService(n int) {
+	sb.maxGrainsPerService = n
+}
+
+// CollapsedResources returns the number of distinct resources collapsed into
+// OtherResource, by service, only listing the services which exceeded their
+// maximum number of grains. It is counted up to MaxCollapsedResources.
+func (sb *StatsRawBucket) CollapsedResources() map[string]int {
+	if len(sb.collapsed) == 0 {
+		return nil
+	}
+	collapsed := make(map[string]int, len(sb.collapsed))
+	for service, resources := range sb.collapsed {
+		collapsed[service] = len(resources)
 	}
+	return collapsed
+}
+
+// SetApdexThresholds sets the thresholds against which the Apdex counts of
+// top-level spans are computed.
+func (sb *StatsRawBucket) SetApdexThresholds(at *ApdexThresholds) {
+	sb.apdex = at
 }
 
 // Export transforms a StatsRawBucket into a StatsBucket, typically used
@@ -122,6 +178,41 @@ func (sb *StatsRawBucket) Export() StatsBucket {
 			TopLevel: v.topLevel,
 			Summary:  v.durationDistribution,
 		}
+		for measure, value := range v.extraCounts {
+			key := GrainKey(k.name, measure, k.aggr)
+			ret.Counts[key] = Count{
+				Key:      key,
+				Name:     k.name,
+				Measure:  measure,
+				TagSet:   v.tags,
+				TopLevel: v.topLevel,
+				Value:    value,
+			}
+			ret.Distributions[key] = Distribution{
+				Key:      key,
+				Name:     k.name,
+				Measure:  measure,
+				TagSet:   v.tags,
+				TopLevel: v.topLevel,
+				Summary:  v.extraDistributions[measure],
+			}
+		}
+		exportErrors(&ret, k, v, ErrorsByType, ErrorTypeTag, v.errorsByType)
+		exportErrors(&ret, k, v, ErrorsByHTTPStatusClass, HTTPStatusClassTag, v.errorsByHTTPStatusClass)
+		if v.apdex != nil {
+			// all of them, so that the score can be derived from any grain
+			for _, measure := range []string{ApdexSatisfied, ApdexTolerating, ApdexFrustrated} {
+				key := GrainKey(k.name, measure, k.aggr)
+				ret.Counts[key] = Count{
+					Key:      key,
+					Name:     k.name,
+					Measure:  measure,
+					TagSet:   v.tags,
+					TopLevel: v.topLevel,
+					Value:    v.apdex[measure],
+				}
+			}
+		}
 	}
 	for k, v := range sb.sublayerData {
 		key := GrainKey(k.name, k.measure, k.aggr)
@@ -137,6 +228,26 @@ func (sb *StatsRawBucket) Export() StatsBucket {
 	return ret
 }
 
+// exportErrors adds to ret the counts of errors broken down by the values of
+// an extra tag, which are part of their grain like the ones of sublayers.
+func exportErrors(ret *StatsBucket, k statsKey, v groupedStats, measure, tagName string, counts map[string]float64) {
+	for value, count := range counts {
+		tags := make(TagSet, len(v.tags)+1)
+		copy(tags, v.tags)
+		tags[len(v.tags)] = Tag{tagName, value}
+
+		key := GrainKey(k.name, measure, k.aggr+","+tagName+":"+value)
+		ret.Counts[key] = Count{
+			Key:      key,
+			Name:     k.name,
+			Measure:  measure,
+			TagSet:   tags,
+			TopLevel: v.topLevel,
+			Value:    count,
+		}
+	}
+}
+
 func assembleGrain(b *bytes.Buffer, env, resource, service string, m map[string]string) (string, TagSet) {
 	b.Reset()
 
@@ -190,6 +301,17 @@ func (sb *StatsRawBucket) HandleSpan(s Span, env string, aggregators []string, s
 	}
 
 	grain, tags := assembleGrain(&sb.keyBuf, env, s.Resource, s.Service, m)
+	if sb.maxGrainsPerService > 0 {
+		if _, ok := sb.data[statsKey{name: s.Name, aggr: grain}]; !ok {
+			if sb.grains[s.Service] >= sb.maxGrainsPerService {
+				// guard against the cardinality explosion of unquantized resources
+				sb.collapse(s.Service, s.Resource)
+				grain, tags = assembleGrain(&sb.keyBuf, env, OtherResource, s.Service, m)
+			} else {
+				sb.grains[s.Service]++
+			}
+		}
+	}
 	sb.add(s, grain, tags)
 
 	// sublayers - special case
@@ -200,6 +322,23 @@ func (sb *StatsRawBucket) HandleSpan(s Span, env string, aggregators []string, s
 	}
 }
 
+// collapse counts a resource collapsed into OtherResource. Only the hashes of
+// the resources are kept, and only up to MaxCollapsedResources of them, for
+// there may be millions of them when resources are not quantized.
+func (sb *StatsRawBucket) collapse(service, resource string) {
+	resources, ok := sb.collapsed[service]
+	if !ok {
+		resources = make(map[uint64]struct{})
+		sb.collapsed[service] = resources
+	}
+	if len(resources) >= MaxCollapsedResources {
+		return
+	}
+	h := fnv.New64a()
+	h.Write([]byte(resource))
+	resources[h.Sum64()] = struct{}{}
+}
+
 func (sb *StatsRawBucket) add(s Span, aggr string, tags TagSet) {
 	var gs groupedStats
 	var ok bool
@@ -216,17 +355,59 @@ func (sb *StatsRawBucket) add(s Span, aggr string, tags TagSet) {
 	gs.hits += s.weight
 	if s.Error != 0 {
 		gs.errors += s.weight
+		sb.addError(&gs, s)
+	}
+	if measure := sb.apdex.apdexMeasure(&s); measure != "" {
+		if gs.apdex == nil {
+			gs.apdex = make(map[string]float64, 3)
+		}
+		gs.apdex[measure] += s.weight
 	}
 	gs.duration += float64(s.Duration) * s.weight
 
-	// TODO add for s.Metrics ability to define arbitrary counts and distros, check some config?
 	// alter resolution of duration distro
 	trundur := nsTimestampToFloat(s.Duration)
 	gs.durationDistribution.Insert(trundur, s.SpanID)
 
+	// extra measures, as cached by Trace.ComputeExtraMeasures
+	for measure, v := range s.extraMeasures {
+		if gs.extraCounts == nil {
+			gs.extraCounts = make(map[string]float64)
+			gs.extraDistributions = make(map[string]*quantile.SliceSummary)
+		}
+		gs.extraCounts[measure] += v * s.weight
+		d, ok := gs.extraDistributions[measure]
+		if !ok {
+			d = quantile.NewSliceSummary()
+			gs.extraDistributions[measure] = d
+		}
+		d.Insert(v, s.SpanID)
+	}
+
 	sb.data[key] = gs
 }
 
+// addError breaks down the error of s by error type and HTTP status class
+func (sb *StatsRawBucket) addError(gs *groupedStats, s Span) {
+	// error types end up in grain keys, they must not break them
+	if errType := NormalizeTag(s.Meta[ErrorTypeTag]); errType != "" {
+		if gs.errorsByType == nil {
+			gs.errorsByType = make(map[string]float64)
+		}
+		if _, ok := gs.errorsByType[errType]; !ok && len(gs.errorsByType) >= sb.maxErrorTypes {
+			errType = OtherErrorType
+		}
+		gs.errorsByType[errType] += s.weight
+	}
+
+	if sc := s.Meta["http.status_code"]; isValidStatusCode(sc) {
+		if gs.errorsByHTTPStatusClass == nil {
+			gs.errorsByHTTPStatusClass = make(map[string]float64)
+		}
+		gs.errorsByHTTPStatusClass[sc[:1]+"xx"] += s.weight
+	}
+}
+
 func (sb *StatsRawBucket) addSublayer(s Span, aggr string, tags TagSet, sub SublayerValue) {
 	// This is not as efficient as a "regular" add as we don't update
 	// all sublayers at once (one call for HITS, and another one for ERRORS, DURATION...)
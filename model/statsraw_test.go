@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,3 +28,187 @@ func TestGrainWithExtraTags(t *testing.T) {
 	assert.Equal("env:default,resource:yo,service:thing,meta1:ONE,meta2:two", aggr)
 	assert.Equal(TagSet{Tag{"env", "default"}, Tag{"resource", "yo"}, Tag{"service", "thing"}, Tag{"meta1", "ONE"}, Tag{"meta2", "two"}}, tgs)
 }
+
+func TestStatsRawBucketExtraMeasures(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+
+	trace := Trace{
+		{SpanID: 1, Service: "db", Name: "query", Resource: "SELECT", Duration: 10,
+			Metrics: map[string]float64{"db.rows": 10, "_sample_rate": 0.5}},
+		{SpanID: 2, Service: "db", Name: "query", Resource: "SELECT", Duration: 10,
+			Metrics: map[string]float64{"db.rows": 4, "other": 1}},
+		{SpanID: 3, Service: "db", Name: "query", Resource: "SELECT", Duration: 10},
+	}
+	trace.ComputeWeight(trace[0])
+	trace.ComputeExtraMeasures([]string{"db.rows", "http.response.size"})
+	for _, s := range trace {
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	sb := srb.Export()
+
+	key := "query|db.rows|env:default,resource:SELECT,service:db"
+	assert.Len(sb.Counts, 4)
+	if assert.Contains(sb.Counts, key) {
+		assert.Equal("db.rows", sb.Counts[key].Measure)
+		assert.Equal(float64(28), sb.Counts[key].Value, "weighted like hits")
+	}
+	assert.Len(sb.Distributions, 2)
+	if assert.Contains(sb.Distributions, key) {
+		assert.Equal(2, sb.Distributions[key].Summary.N)
+	}
+}
+
+func TestStatsRawBucketErrorsBreakdown(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.SetMaxErrorTypes(2)
+
+	for _, s := range []Span{
+		{SpanID: 1, Error: 1, Meta: map[string]string{"error.type": "Timeout", "http.status_code": "504"}},
+		{SpanID: 2, Error: 1, Meta: map[string]string{"error.type": "timeout", "http.status_code": "500"}},
+		{SpanID: 3, Error: 1, Meta: map[string]string{"error.type": "KeyError", "http.status_code": "404"}},
+		// beyond the cap of distinct error types
+		{SpanID: 4, Error: 1, Meta: map[string]string{"error.type": "ValueError"}},
+		{SpanID: 5, Error: 1, Meta: map[string]string{"error.type": "IOError", "http.status_code": "5xx"}},
+		// not an error
+		{SpanID: 6, Meta: map[string]string{"error.type": "Timeout", "http.status_code": "200"}},
+	} {
+		s.Service, s.Name, s.Resource, s.weight = "web", "request", "GET /", 1
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	sb := srb.Export()
+
+	expected := map[string]float64{
+		"request|errors.by_type|env:default,resource:GET /,service:web,error.type:timeout":                 2,
+		"request|errors.by_type|env:default,resource:GET /,service:web,error.type:keyerror":                1,
+		"request|errors.by_type|env:default,resource:GET /,service:web,error.type:_other":                  2,
+		"request|errors.by_http_status_class|env:default,resource:GET /,service:web,http.status_class:5xx": 2,
+		"request|errors.by_http_status_class|env:default,resource:GET /,service:web,http.status_class:4xx": 1,
+	}
+	assert.Len(sb.Counts, len(expected)+3)
+	for key, v := range expected {
+		if assert.Contains(sb.Counts, key) {
+			assert.Equal(v, sb.Counts[key].Value, key)
+		}
+	}
+
+	c := sb.Counts["request|errors.by_type|env:default,resource:GET /,service:web,error.type:_other"]
+	assert.Equal(ErrorsByType, c.Measure)
+	assert.Equal(TagSet{{"env", "default"}, {"resource", "GET /"}, {"service", "web"}, {"error.type", "_other"}}, c.TagSet)
+}
+
+func TestStatsRawBucketErrorTypeNormalized(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+
+	for _, errType := range []string{"Net::ReadTimeout, retrying", "net::readtimeout retrying", "!!!"} {
+		s := Span{Service: "web", Name: "request", Resource: "GET /", Error: 1, weight: 1}
+		s.Meta = map[string]string{"error.type": errType}
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	sb := srb.Export()
+
+	key := "request|errors.by_type|env:default,resource:GET /,service:web,error.type:net::readtimeout_retrying"
+	if assert.Contains(sb.Counts, key) {
+		assert.Equal(float64(2), sb.Counts[key].Value)
+		assert.Equal(Tag{"error.type", "net::readtimeout_retrying"}, sb.Counts[key].TagSet[3])
+	}
+	// nothing left of the last one, only counted in errors
+	assert.Len(sb.Counts, 4)
+	assert.Equal(float64(3), sb.Counts["request|errors|env:default,resource:GET /,service:web"].Value)
+}
+
+func TestStatsRawBucketApdex(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.SetApdexThresholds(NewApdexThresholds(100))
+
+	for _, s := range []Span{
+		{SpanID: 1, Resource: "GET /", Duration: 50, topLevel: true},
+		{SpanID: 2, Resource: "GET /", Duration: 150, topLevel: true},
+		{SpanID: 3, Resource: "GET /", Duration: 50, topLevel: true},
+		// not top-level, no Apdex
+		{SpanID: 4, Resource: "GET /", Duration: 50},
+		{SpanID: 5, Resource: "SELECT", Duration: 50},
+	} {
+		s.Service, s.Name, s.weight = "web", "request", 2
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	sb := srb.Export()
+
+	for measure, v := range map[string]float64{
+		ApdexSatisfied:  4,
+		ApdexTolerating: 2,
+		ApdexFrustrated: 0,
+	} {
+		key := GrainKey("request", measure, "env:default,resource:GET /,service:web")
+		if assert.Contains(sb.Counts, key) {
+			assert.Equal(v, sb.Counts[key].Value, key)
+		}
+		assert.NotContains(sb.Counts, GrainKey("request", measure, "env:default,resource:SELECT,service:web"))
+	}
+}
+
+func TestStatsRawBucketMaxGrainsPerService(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.SetMaxGrainsPerService(2)
+
+	for _, s := range []Span{
+		{Service: "web", Resource: "GET /users/1"},
+		{Service: "web", Resource: "GET /users/2"},
+		// known grains are still aggregated
+		{Service: "web", Resource: "GET /users/1"},
+		{Service: "web", Resource: "GET /users/3"},
+		{Service: "web", Resource: "GET /users/4"},
+		{Service: "web", Resource: "GET /users/4"},
+		// other services have their own limit
+		{Service: "db", Resource: "SELECT"},
+	} {
+		s.Name, s.weight = "request", 1
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	assert.Equal(map[string]int{"web": 2}, srb.CollapsedResources())
+
+	sb := srb.Export()
+	for resource, hits := range map[string]float64{
+		"GET /users/1": 2,
+		"GET /users/2": 1,
+		"_other":       3,
+	} {
+		key := "request|hits|env:default,resource:" + resource + ",service:web"
+		if assert.Contains(sb.Counts, key) {
+			assert.Equal(hits, sb.Counts[key].Value, key)
+		}
+	}
+	assert.Contains(sb.Counts, "request|hits|env:default,resource:SELECT,service:db")
+	assert.NotContains(sb.Counts, "request|hits|env:default,resource:GET /users/3,service:web")
+
+	srb = NewStatsRawBucket(0, 1e9)
+	srb.SetMaxGrainsPerService(0)
+	for i := 0; i < 5; i++ {
+		srb.HandleSpan(Span{Service: "web", Name: "request", Resource: strconv.Itoa(i)}, "default", nil, nil)
+	}
+	assert.Nil(srb.CollapsedResources())
+}
+
+func TestStatsRawBucketMaxGrainsPerServiceMemory(t *testing.T) {
+	assert := assert.New(t)
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.SetMaxGrainsPerService(10)
+
+	// unquantized resources, way past the cap
+	const n = 3 * MaxCollapsedResources
+	for i := 0; i < n; i++ {
+		s := Span{Service: "web", Name: "request", Resource: "GET /users/" + strconv.Itoa(i), weight: 1}
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+
+	assert.Len(srb.data, 11, "the grains under the cap, and the _other one")
+	assert.Len(srb.collapsed["web"], MaxCollapsedResources)
+	assert.Equal(map[string]int{"web": MaxCollapsedResources}, srb.CollapsedResources())
+
+	key := "request|hits|env:default,resource:_other,service:web"
+	assert.Equal(float64(n-10), srb.Export().Counts[key].Value)
+}
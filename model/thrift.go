@@ -0,0 +1,235 @@
+package model
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Thrift types, as encoded by the binary protocol
+const (
+	thriftStop   byte = 0
+	thriftBool   byte = 2
+	thriftByte   byte = 3
+	thriftDouble byte = 4
+	thriftI16    byte = 6
+	thriftI32    byte = 8
+	thriftI64    byte = 10
+	thriftString byte = 11
+	thriftStruct byte = 12
+	thriftMap    byte = 13
+	thriftSet    byte = 14
+	thriftList   byte = 15
+)
+
+const (
+	// thriftMaxLen caps the size of strings and collections we accept, so
+	// that a corrupted length does not make us allocate too much memory
+	thriftMaxLen = 16 * 1024 * 1024
+	// thriftMaxDepth caps the nesting of skipped values
+	thriftMaxDepth = 64
+)
+
+// thriftReader reads values encoded with the Thrift binary protocol. It only
+// implements what is needed to decode the payloads we receive, and fails
+// instead of allocating huge buffers on bogus lengths.
+type thriftReader struct {
+	r   io.Reader
+	buf [8]byte
+}
+
+func newThriftReader(r io.Reader) *thriftReader {
+	return &thriftReader{r: r}
+}
+
+func (tr *thriftReader) read(n int) ([]byte, error) {
+	b := tr.buf[:n]
+	_, err := io.ReadFull(tr.r, b)
+	return b, err
+}
+
+func (tr *thriftReader) readByte() (byte, error) {
+	b, err := tr.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (tr *thriftReader) readBool() (bool, error) {
+	b, err := tr.readByte()
+	return b != 0, err
+}
+
+func (tr *thriftReader) readI16() (int16, error) {
+	b, err := tr.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(b)), nil
+}
+
+func (tr *thriftReader) readI32() (int32, error) {
+	b, err := tr.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (tr *thriftReader) readI64() (int64, error) {
+	b, err := tr.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (tr *thriftReader) readDouble() (float64, error) {
+	b, err := tr.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+// readLen reads the length of a string or a collection
+func (tr *thriftReader) readLen() (int, error) {
+	n, err := tr.readI32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > thriftMaxLen {
+		return 0, fmt.Errorf("thrift: invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+func (tr *thriftReader) readBinary() ([]byte, error) {
+	n, err := tr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(tr.r, b)
+	return b, err
+}
+
+func (tr *thriftReader) readString() (string, error) {
+	b, err := tr.readBinary()
+	return string(b), err
+}
+
+// readFieldBegin returns the type and the ID of the next field of a struct,
+// the type being thriftStop at the end of the struct.
+func (tr *thriftReader) readFieldBegin() (byte, int16, error) {
+	t, err := tr.readByte()
+	if err != nil || t == thriftStop {
+		return t, 0, err
+	}
+	id, err := tr.readI16()
+	return t, id, err
+}
+
+// readListBegin returns the type and the number of elements of a list or a set
+func (tr *thriftReader) readListBegin() (byte, int, error) {
+	t, err := tr.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := tr.readLen()
+	return t, n, err
+}
+
+// readStruct reads a struct, calling readField for each of its fields. Fields
+// readField does not know about have to be skipped by it.
+func (tr *thriftReader) readStruct(readField func(t byte, id int16) error) error {
+	for {
+		t, id, err := tr.readFieldBegin()
+		if err != nil {
+			return err
+		}
+		if t == thriftStop {
+			return nil
+		}
+		if err := readField(t, id); err != nil {
+			return err
+		}
+	}
+}
+
+// readList reads a list of elements of type elemType, calling readElem for
+// each of them.
+func (tr *thriftReader) readList(elemType byte, readElem func() error) error {
+	t, n, err := tr.readListBegin()
+	if err != nil {
+		return err
+	}
+	if t != elemType {
+		return fmt.Errorf("thrift: expected a list of type %d, got %d", elemType, t)
+	}
+	for i := 0; i < n; i++ {
+		if err := readElem(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip reads and discards a value of the given type
+func (tr *thriftReader) skip(t byte) error {
+	return tr.skipDepth(t, 0)
+}
+
+func (tr *thriftReader) skipDepth(t byte, depth int) error {
+	if depth > thriftMaxDepth {
+		return fmt.Errorf("thrift: maximum depth exceeded")
+	}
+	var err error
+	switch t {
+	case thriftBool, thriftByte:
+		_, err = tr.read(1)
+	case thriftI16:
+		_, err = tr.read(2)
+	case thriftI32:
+		_, err = tr.read(4)
+	case thriftDouble, thriftI64:
+		_, err = tr.read(8)
+	case thriftString:
+		_, err = tr.readBinary()
+	case thriftStruct:
+		err = tr.readStruct(func(t byte, _ int16) error {
+			return tr.skipDepth(t, depth+1)
+		})
+	case thriftMap:
+		var kt, vt byte
+		var n int
+		if kt, err = tr.readByte(); err != nil {
+			return err
+		}
+		if vt, err = tr.readByte(); err != nil {
+			return err
+		}
+		if n, err = tr.readLen(); err != nil {
+			return err
+		}
+		for i := 0; i < n && err == nil; i++ {
+			if err = tr.skipDepth(kt, depth+1); err == nil {
+				err = tr.skipDepth(vt, depth+1)
+			}
+		}
+	case thriftSet, thriftList:
+		var et byte
+		var n int
+		if et, n, err = tr.readListBegin(); err != nil {
+			return err
+		}
+		for i := 0; i < n && err == nil; i++ {
+			err = tr.skipDepth(et, depth+1)
+		}
+	default:
+		err = fmt.Errorf("thrift: unknown type %d", t)
+	}
+	return err
+}
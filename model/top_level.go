@@ -3,8 +3,10 @@ package model
 const (
 	// TraceMetricsKey is a tag key which, if set to true,
 	// ensures all statistics are computed for this span.
-	// [FIXME] *not implemented yet*
 	TraceMetricsKey = "datadog.trace_metrics"
+	// MeasuredKey is a metric key which, if set to 1 by the client, ensures
+	// all statistics are computed for this span even if it is not top-level.
+	MeasuredKey = "_dd.measured"
 
 	// This is a special metric, it's 1 if the span is top-level, 0 if not.
 	topLevelKey  = "_top_level"
@@ -20,11 +22,7 @@ const (
 //   being the highest ancestor of other spans belonging to this service and
 //   attached to it).
 func (t Trace) ComputeTopLevel() {
-	// build a lookup map
-	spanIDToIdx := make(map[uint64]int, len(t))
-	for i, span := range t {
-		spanIDToIdx[span.SpanID] = i
-	}
+	spanIDToIdx := t.spanIDToIdx()
 
 	// iterate on each span and mark them as top-level if relevant
 	for i, span := range t {
@@ -37,6 +35,16 @@ func (t Trace) ComputeTopLevel() {
 	}
 }
 
+// spanIDToIdx builds a lookup map of the index of each span by its ID, used to
+// find the parent of a span.
+func (t Trace) spanIDToIdx() map[uint64]int {
+	spanIDToIdx := make(map[uint64]int, len(t))
+	for i, span := range t {
+		spanIDToIdx[span.SpanID] = i
+	}
+	return spanIDToIdx
+}
+
 // setTopLevel sets the top-level attribute of the span.
 func (s *Span) setTopLevel(topLevel bool) {
 	if !topLevel {
@@ -70,3 +78,24 @@ func (s *Span) TopLevel() bool {
 func (s *Span) ForceMetrics() bool {
 	return s.Meta[TraceMetricsKey] == trueTagValue
 }
+
+// Measured returns true if the client marked the span as measured, or forced
+// statistics computation for it.
+func (s *Span) Measured() bool {
+	return s.Metrics[MeasuredKey] == 1 || s.ForceMetrics()
+}
+
+// ComputeMeasured caches whether each span is measured, for FullStats.
+func (t Trace) ComputeMeasured() {
+	for i := range t {
+		t[i].measured = t[i].Measured()
+	}
+}
+
+// FullStats returns true if all statistics should be computed for this span,
+// which is the case of top-level and measured spans. It relies on the values
+// cached by ComputeTopLevel and ComputeMeasured, so that it can be called
+// while the Metrics map is being updated.
+func (s *Span) FullStats() bool {
+	return s.topLevel || s.measured
+}
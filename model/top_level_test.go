@@ -188,3 +188,35 @@ func TestForceMetrics(t *testing.T) {
 	span.Meta = map[string]string{"env": "dev"}
 	assert.False(span.ForceMetrics(), "there's a tag, but metrics should not be enforced anyway")
 }
+
+func TestMeasured(t *testing.T) {
+	assert := assert.New(t)
+
+	span := Span{}
+	assert.False(span.Measured())
+	span.Metrics = map[string]float64{"_dd.measured": 1}
+	assert.True(span.Measured(), "marked as measured by the client")
+	span.Metrics = nil
+	span.Meta = map[string]string{"datadog.trace_metrics": "true"}
+	assert.True(span.Measured(), "forced metrics are measured too")
+}
+
+func TestFullStats(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := Trace{
+		Span{TraceID: 1, SpanID: 1, ParentID: 0, Service: "mcnulty", Type: "web"},
+		Span{TraceID: 1, SpanID: 2, ParentID: 1, Service: "mcnulty", Type: "sql"},
+		Span{TraceID: 1, SpanID: 3, ParentID: 1, Service: "mcnulty", Type: "sql",
+			Metrics: map[string]float64{"_dd.measured": 1}},
+		Span{TraceID: 1, SpanID: 4, ParentID: 1, Service: "mcnulty", Type: "sql",
+			Meta: map[string]string{"datadog.trace_metrics": "true"}},
+	}
+	tr.ComputeTopLevel()
+	tr.ComputeMeasured()
+
+	assert.True(tr[0].FullStats(), "top-level")
+	assert.False(tr[1].FullStats(), "internal")
+	assert.True(tr[2].FullStats(), "measured")
+	assert.True(tr[3].FullStats(), "forced metrics")
+}
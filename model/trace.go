@@ -53,7 +53,7 @@ func (t Trace) GetRoot() *Span {
 
 	// Here, if the trace is valid, we should have len(parentIDToChild) == 1
 	if len(parentIDToChild) != 1 {
-		log.Debugf("didn't reliably find the root span for traceID:%v", t[0].TraceID)
+		log.Debugf("didn't reliably find the root span for traceID:%v", t[0].TraceKey())
 	}
 
 	// Have a safe bahavior if that's not the case
@@ -81,3 +81,21 @@ func (t Trace) ComputeWeight(root Span) {
 		t[i].weight = weight
 	}
 }
+
+// ComputeExtraMeasures caches the values of the given metrics of each span,
+// which are then aggregated as extra measures along with hits, errors and
+// duration. Like the weight, they must be cached before computing stats as
+// the Metrics map is not thread safe.
+func (t Trace) ComputeExtraMeasures(keys []string) {
+	for i := range t {
+		t[i].extraMeasures = nil
+		for _, k := range keys {
+			if v, ok := t[i].Metrics[k]; ok {
+				if t[i].extraMeasures == nil {
+					t[i].extraMeasures = make(map[string]float64, len(keys))
+				}
+				t[i].extraMeasures[k] = v
+			}
+		}
+	}
+}
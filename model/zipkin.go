@@ -0,0 +1,196 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ZipkinEndpoint is the network context of a node in the Zipkin v2 model
+type ZipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int    `json:"port"`
+}
+
+// ZipkinAnnotation is an event which happened at a given time during a Zipkin span
+type ZipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"` // microsecond epoch
+	Value     string `json:"value"`
+}
+
+// ZipkinSpan is a span as sent to the Zipkin v2 JSON API, see
+// https://zipkin.io/zipkin-api/#/default/post_spans
+type ZipkinSpan struct {
+	TraceID        string             `json:"traceId"` // 16 or 32 hex characters
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`      // CLIENT, SERVER, PRODUCER or CONSUMER
+	Timestamp      int64              `json:"timestamp"` // microsecond epoch
+	Duration       int64              `json:"duration"`  // in microseconds
+	Debug          bool               `json:"debug"`
+	Shared         bool               `json:"shared"`
+	LocalEndpoint  *ZipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *ZipkinEndpoint    `json:"remoteEndpoint"`
+	Annotations    []ZipkinAnnotation `json:"annotations"`
+	Tags           map[string]string  `json:"tags"`
+}
+
+// zipkinErrorKey is the tag, or annotation, Zipkin tracers use to flag errors
+const zipkinErrorKey = "error"
+
+// parseZipkinID parses a hex encoded Zipkin ID. IDs longer than 64 bits are
+// truncated to their lower 64 bits, use parseZipkinTraceID for trace IDs.
+func parseZipkinID(id string) (uint64, error) {
+	if len(id) > 32 {
+		return 0, fmt.Errorf("invalid zipkin id %q: longer than 128 bits", id)
+	}
+	if len(id) > 16 {
+		id = id[len(id)-16:]
+	}
+	v, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zipkin id %q: %v", id, err)
+	}
+	return v, nil
+}
+
+// parseZipkinTraceID parses a hex encoded Zipkin trace ID, returning its upper
+// 64 bits, 0 for 64-bit IDs, and its lower 64 bits.
+func parseZipkinTraceID(id string) (uint64, uint64, error) {
+	if len(id) <= 16 {
+		low, err := parseZipkinID(id)
+		return 0, low, err
+	}
+	if len(id) > 32 {
+		return 0, 0, fmt.Errorf("invalid zipkin id %q: longer than 128 bits", id)
+	}
+	high, err := parseZipkinID(id[:len(id)-16])
+	if err != nil {
+		return 0, 0, err
+	}
+	low, err := parseZipkinID(id[len(id)-16:])
+	return high, low, err
+}
+
+// ToSpan converts a Zipkin span to our own model. The resulting span still
+// has to be normalized.
+func (zs *ZipkinSpan) ToSpan() (Span, error) {
+	var s Span
+	var err error
+
+	if s.TraceIDHigh, s.TraceID, err = parseZipkinTraceID(zs.TraceID); err != nil {
+		return s, err
+	}
+	if s.SpanID, err = parseZipkinID(zs.ID); err != nil {
+		return s, err
+	}
+	if zs.ParentID != "" {
+		if s.ParentID, err = parseZipkinID(zs.ParentID); err != nil {
+			return s, err
+		}
+	}
+
+	if zs.LocalEndpoint != nil {
+		s.Service = zs.LocalEndpoint.ServiceName
+	}
+	s.Name = zs.Name
+	if s.Name == "" && zs.Kind != "" {
+		s.Name = "zipkin." + strings.ToLower(zs.Kind)
+	}
+	s.Start = zs.Timestamp * 1000
+	s.Duration = zs.Duration * 1000
+
+	s.Meta = make(map[string]string, len(zs.Tags)+3)
+	for k, v := range zs.Tags {
+		s.Meta[k] = v
+	}
+
+	s.Resource = s.Name
+	if method, path := zs.Tags["http.method"], zs.Tags["http.path"]; method != "" && path != "" {
+		s.Resource = method + " " + path
+	}
+
+	if zs.Kind != "" {
+		s.Meta["span.kind"] = strings.ToLower(zs.Kind)
+	}
+	switch {
+	case zs.Kind == "SERVER":
+		s.Type = "web"
+	case zs.Kind == "CLIENT" && zs.Tags["http.method"] != "":
+		s.Type = "http"
+	}
+
+	if zs.RemoteEndpoint != nil {
+		if zs.RemoteEndpoint.ServiceName != "" {
+			s.Meta["peer.service"] = zs.RemoteEndpoint.ServiceName
+		}
+		if host := zs.RemoteEndpoint.IPv4; host != "" {
+			s.Meta["out.host"] = host
+		} else if host := zs.RemoteEndpoint.IPv6; host != "" {
+			s.Meta["out.host"] = host
+		}
+		if zs.RemoteEndpoint.Port != 0 {
+			s.Meta["out.port"] = strconv.Itoa(zs.RemoteEndpoint.Port)
+		}
+	}
+
+	if msg, ok := zs.Tags[zipkinErrorKey]; ok {
+		s.Error = 1
+		delete(s.Meta, zipkinErrorKey)
+		if msg != "" && msg != "true" {
+			s.Meta["error.msg"] = msg
+		}
+	}
+	for _, a := range zs.Annotations {
+		if a.Value == zipkinErrorKey {
+			s.Error = 1
+		}
+	}
+
+	if zs.Debug {
+		s.Metrics = map[string]float64{SamplingPriorityKey: debugSamplingPriority}
+	}
+
+	return s, nil
+}
+
+// TracesFromZipkinSpans converts Zipkin spans to our model and groups them
+// by trace. Zipkin allows a server span to share its ID with the client span
+// which called it: such client spans get a new ID, and become the parent of
+// the server span, so that span IDs remain unique.
+func TracesFromZipkinSpans(zspans []ZipkinSpan) (Traces, error) {
+	type spanKey struct {
+		trace  TraceKey
+		spanID uint64
+	}
+
+	spans := make([]Span, len(zspans))
+	byID := make(map[spanKey]int, len(zspans)) // non-shared spans
+	for i := range zspans {
+		s, err := zspans[i].ToSpan()
+		if err != nil {
+			return nil, err
+		}
+		spans[i] = s
+		if !zspans[i].Shared {
+			byID[spanKey{s.TraceKey(), s.SpanID}] = i
+		}
+	}
+
+	for i := range zspans {
+		if !zspans[i].Shared {
+			continue
+		}
+		client, ok := byID[spanKey{spans[i].TraceKey(), spans[i].SpanID}]
+		if !ok {
+			continue
+		}
+		spans[client].SpanID = RandomID()
+		spans[i].ParentID = spans[client].SpanID
+	}
+
+	return TracesFromSpans(spans), nil
+}
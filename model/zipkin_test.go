@@ -0,0 +1,178 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseZipkinID(t *testing.T) {
+	assert := assert.New(t)
+
+	id, err := parseZipkinID("000000000000002a")
+	assert.Nil(err)
+	assert.Equal(uint64(42), id)
+
+	id, err = parseZipkinID("2a")
+	assert.Nil(err)
+	assert.Equal(uint64(42), id)
+
+	// 128-bit IDs keep their lower 64 bits
+	id, err = parseZipkinID("463ac35c9f6413ad48485a3953bb6124")
+	assert.Nil(err)
+	assert.Equal(uint64(0x48485a3953bb6124), id)
+
+	for _, invalid := range []string{"", "not-hex", "463ac35c9f6413ad48485a3953bb61240"} {
+		_, err = parseZipkinID(invalid)
+		assert.NotNil(err, invalid)
+	}
+}
+
+func TestParseZipkinTraceID(t *testing.T) {
+	assert := assert.New(t)
+
+	high, low, err := parseZipkinTraceID("2a")
+	assert.Nil(err)
+	assert.Equal(uint64(0), high)
+	assert.Equal(uint64(42), low)
+
+	high, low, err = parseZipkinTraceID("463ac35c9f6413ad48485a3953bb6124")
+	assert.Nil(err)
+	assert.Equal(uint64(0x463ac35c9f6413ad), high)
+	assert.Equal(uint64(0x48485a3953bb6124), low)
+
+	high, low, err = parseZipkinTraceID("1000000000000002a")
+	assert.Nil(err)
+	assert.Equal(uint64(1), high)
+	assert.Equal(uint64(42), low)
+
+	for _, invalid := range []string{"", "not-hex", "zz48485a3953bb6124", "463ac35c9f6413ad48485a3953bb61240"} {
+		_, _, err = parseZipkinTraceID(invalid)
+		assert.NotNil(err, invalid)
+	}
+}
+
+func TestZipkin128BitTraces(t *testing.T) {
+	assert := assert.New(t)
+
+	// traces sharing the lower 64 bits of their IDs are not mixed up
+	traces, err := TracesFromZipkinSpans([]ZipkinSpan{
+		{TraceID: "463ac35c9f6413ad000000000000002a", ID: "1", Name: "a"},
+		{TraceID: "563ac35c9f6413ad000000000000002a", ID: "1", Name: "b"},
+		{TraceID: "463ac35c9f6413ad000000000000002a", ID: "2", ParentID: "1", Name: "a"},
+	})
+	assert.Nil(err)
+	assert.Len(traces, 2)
+	for _, trace := range traces {
+		for _, s := range trace {
+			assert.Equal(trace[0].TraceKey(), s.TraceKey())
+			assert.Equal(trace[0].Name, s.Name)
+		}
+	}
+}
+
+func TestZipkinSpanToSpan(t *testing.T) {
+	assert := assert.New(t)
+
+	var zs ZipkinSpan
+	err := json.Unmarshal([]byte(`{
+		"traceId": "463ac35c9f6413ad48485a3953bb6124",
+		"id": "000000000000002b",
+		"parentId": "000000000000002a",
+		"name": "get /users",
+		"kind": "SERVER",
+		"timestamp": 1502787600000000,
+		"duration": 1500,
+		"debug": true,
+		"localEndpoint": {"serviceName": "frontend", "ipv4": "10.0.0.1"},
+		"remoteEndpoint": {"serviceName": "mobile", "ipv4": "10.0.0.2", "port": 8080},
+		"annotations": [{"timestamp": 1502787600000100, "value": "error"}],
+		"tags": {"http.method": "GET", "http.path": "/users", "http.status_code": "500"}
+	}`), &zs)
+	assert.Nil(err)
+
+	s, err := zs.ToSpan()
+	assert.Nil(err)
+	assert.Equal(uint64(0x48485a3953bb6124), s.TraceID)
+	assert.Equal(uint64(0x463ac35c9f6413ad), s.TraceIDHigh)
+	assert.Equal(uint64(43), s.SpanID)
+	assert.Equal(uint64(42), s.ParentID)
+	assert.Equal("frontend", s.Service)
+	assert.Equal("get /users", s.Name)
+	assert.Equal("GET /users", s.Resource)
+	assert.Equal("web", s.Type)
+	assert.Equal(int64(1502787600000000000), s.Start)
+	assert.Equal(int64(1500000), s.Duration)
+	assert.Equal(int32(1), s.Error)
+	assert.Equal(map[string]string{
+		"http.method":      "GET",
+		"http.path":        "/users",
+		"http.status_code": "500",
+		"span.kind":        "server",
+		"peer.service":     "mobile",
+		"out.host":         "10.0.0.2",
+		"out.port":         "8080",
+	}, s.Meta)
+	assert.Equal(float64(debugSamplingPriority), s.Metrics[SamplingPriorityKey])
+
+	assert.Nil(s.Normalize())
+	assert.Equal("get_users", s.Name)
+}
+
+func TestZipkinSpanErrorTag(t *testing.T) {
+	assert := assert.New(t)
+
+	zs := ZipkinSpan{
+		TraceID: "1",
+		ID:      "2",
+		Name:    "query",
+		Tags:    map[string]string{"error": "connection refused"},
+	}
+	s, err := zs.ToSpan()
+	assert.Nil(err)
+	assert.Equal(int32(1), s.Error)
+	assert.Equal(map[string]string{"error.msg": "connection refused"}, s.Meta)
+	assert.Equal("query", s.Resource)
+	assert.Nil(s.Metrics)
+}
+
+func TestTracesFromZipkinSpans(t *testing.T) {
+	assert := assert.New(t)
+
+	zspans := []ZipkinSpan{
+		{TraceID: "1", ID: "1", Name: "root", Kind: "CLIENT"},
+		{TraceID: "1", ID: "2", ParentID: "1", Name: "call", Kind: "CLIENT"},
+		// the server side of the call, sharing its ID
+		{TraceID: "1", ID: "2", ParentID: "1", Name: "handle", Kind: "SERVER", Shared: true},
+		{TraceID: "1", ID: "3", ParentID: "2", Name: "query"},
+		{TraceID: "2", ID: "4", Name: "other"},
+	}
+
+	traces, err := TracesFromZipkinSpans(zspans)
+	assert.Nil(err)
+	assert.Len(traces, 2)
+
+	var trace Trace
+	for _, t := range traces {
+		if len(t) == 4 {
+			trace = t
+		}
+	}
+	if !assert.NotNil(trace) {
+		return
+	}
+
+	byName := make(map[string]Span)
+	for _, s := range trace {
+		byName[s.Name] = s
+	}
+	assert.Equal(uint64(1), byName["call"].ParentID)
+	assert.NotEqual(uint64(2), byName["call"].SpanID)
+	assert.Equal(uint64(2), byName["handle"].SpanID)
+	assert.Equal(byName["call"].SpanID, byName["handle"].ParentID)
+	assert.Equal(uint64(2), byName["query"].ParentID)
+
+	_, err = TracesFromZipkinSpans([]ZipkinSpan{{TraceID: "zz", ID: "1"}})
+	assert.NotNil(err)
+}
@@ -13,6 +13,10 @@ import (
 type Backend struct {
 	// Score per signature
 	scores map[Signature]float64
+	// Score per service signature, used to compute the rates we advertise to clients
+	serviceScores map[ServiceSignature]float64
+	// Sum of the sample rates of the traces counted in serviceScores, decayed the same way
+	serviceRates map[ServiceSignature]float64
 	// Score of all traces (equals the sum of all signature scores)
 	totalScore float64
 	// Score of sampled traces
@@ -42,6 +46,8 @@ func NewBackend(decayPeriod time.Duration) *Backend {
 
 	return &Backend{
 		scores:           make(map[Signature]float64),
+		serviceScores:    make(map[ServiceSignature]float64),
+		serviceRates:     make(map[ServiceSignature]float64),
 		sampledScore:     0,
 		decayPeriod:      decayPeriod,
 		decayFactor:      decayFactor,
@@ -78,6 +84,15 @@ func (b *Backend) CountSignature(signature Signature) {
 	b.mu.Unlock()
 }
 
+// CountServiceSignature counts an incoming service signature, along with the
+// sample rate its trace is subject to
+func (b *Backend) CountServiceSignature(signature ServiceSignature, sampleRate float64) {
+	b.mu.Lock()
+	b.serviceScores[signature]++
+	b.serviceRates[signature] += sampleRate
+	b.mu.Unlock()
+}
+
 // CountSample counts a trace sampled by the sampler
 func (b *Backend) CountSample() {
 	b.mu.Lock()
@@ -95,6 +110,32 @@ func (b *Backend) GetSignatureScore(signature Signature) float64 {
 	return score
 }
 
+// GetServiceScores returns the scores of all the service signatures seen recently.
+// They are normalized to represent a number of traces per second.
+func (b *Backend) GetServiceScores() map[ServiceSignature]float64 {
+	b.mu.Lock()
+	scores := make(map[ServiceSignature]float64, len(b.serviceScores))
+	for sig, score := range b.serviceScores {
+		scores[sig] = score / b.countScaleFactor
+	}
+	b.mu.Unlock()
+
+	return scores
+}
+
+// GetServiceSampleRates returns the average sample rate the recent traces of
+// each service signature were subject to, the most recent ones weighing more.
+func (b *Backend) GetServiceSampleRates() map[ServiceSignature]float64 {
+	b.mu.Lock()
+	rates := make(map[ServiceSignature]float64, len(b.serviceScores))
+	for sig, score := range b.serviceScores {
+		rates[sig] = b.serviceRates[sig] / score
+	}
+	b.mu.Unlock()
+
+	return rates
+}
+
 // GetSampledScore returns the global score of all sampled traces.
 func (b *Backend) GetSampledScore() float64 {
 	b.mu.Lock()
@@ -140,6 +181,16 @@ func (b *Backend) DecayScore() {
 			delete(b.scores, sig)
 		}
 	}
+	for sig := range b.serviceScores {
+		score := b.serviceScores[sig]
+		if score > b.decayFactor*minSignatureScoreOffset {
+			b.serviceScores[sig] /= b.decayFactor
+			b.serviceRates[sig] /= b.decayFactor
+		} else {
+			delete(b.serviceScores, sig)
+			delete(b.serviceRates, sig)
+		}
+	}
 	b.totalScore /= b.decayFactor
 	b.sampledScore /= b.decayFactor
 	b.mu.Unlock()
@@ -90,3 +90,35 @@ func TestCountScoreOblivion(t *testing.T) {
 
 	assert.True(backend.GetSignatureScore(sign) < 0.01*float64(tracesPerPeriod))
 }
+
+func TestServiceScores(t *testing.T) {
+	assert := assert.New(t)
+	backend := getTestBackend()
+
+	sig := ServiceSignature{Name: "mcnulty", Env: "prod"}
+	for i := 0; i < 1000; i++ {
+		rate := 0.2
+		if i%4 == 0 {
+			rate = 1
+		}
+		backend.CountServiceSignature(sig, rate)
+	}
+
+	scores := backend.GetServiceScores()
+	assert.Len(scores, 1)
+	assert.InEpsilon(1000/backend.countScaleFactor, scores[sig], 0.01)
+	// the average rate of its traces
+	assert.InEpsilon(0.4, backend.GetServiceSampleRates()[sig], 0.01)
+	backend.DecayScore()
+	assert.InEpsilon(0.4, backend.GetServiceSampleRates()[sig], 0.01)
+
+	// service scores do not pollute the global score
+	assert.Equal(0.0, backend.GetTotalScore())
+
+	// and they eventually get forgotten
+	for i := 0; i < 100; i++ {
+		backend.DecayScore()
+	}
+	assert.Len(backend.GetServiceScores(), 0)
+	assert.Len(backend.GetServiceSampleRates(), 0)
+}
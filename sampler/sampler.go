@@ -126,8 +126,14 @@ func (s *Sampler) Sample(trace model.Trace, root *model.Span, env string) bool {
 
 	// Update sampler state by counting this trace
 	s.Backend.CountSignature(signature)
-
 	sampleRate := s.GetSampleRate(trace, root, signature)
+	s.Backend.CountServiceSignature(ServiceSignature{Name: root.Service, Env: env}, sampleRate)
+
+	// The client already made a decision for this trace, honor it.
+	// It still has been counted above, so that the rates we advertise stay accurate.
+	if priority, ok := GetSamplingPriority(root); ok {
+		return s.samplePriority(priority)
+	}
 
 	sampled := ApplySampleRate(root, sampleRate)
 
@@ -147,6 +153,19 @@ func (s *Sampler) Sample(trace model.Trace, root *model.Span, env string) bool {
 	return sampled
 }
 
+// samplePriority tells if a trace with the given sampling priority has to be kept.
+// Traces the client asked to keep are never subject to the maxTPS limit.
+func (s *Sampler) samplePriority(priority int) bool {
+	if priority <= 0 {
+		return false
+	}
+
+	// Still count it, it is part of the traces we send
+	s.Backend.CountSample()
+
+	return true
+}
+
 // GetSampleRate returns the sample rate to apply to a trace.
 func (s *Sampler) GetSampleRate(trace model.Trace, root *model.Span, signature Signature) float64 {
 	sampleRate := s.GetSignatureSampleRate(signature) * s.extraRate
@@ -175,9 +194,7 @@ func ApplySampleRate(root *model.Span, sampleRate float64) bool {
 	newRate := initialRate * sampleRate
 	SetTraceAppliedSampleRate(root, newRate)
 
-	traceID := root.TraceID
-
-	return SampleByRate(traceID, newRate)
+	return SampleTraceByRate(root.TraceIDHigh, root.TraceID, newRate)
 }
 
 // GetTraceAppliedSampleRate gets the sample rate the sample rate applied earlier in the pipeline.
@@ -189,6 +206,12 @@ func GetTraceAppliedSampleRate(root *model.Span) float64 {
 	return 1.0
 }
 
+// GetSamplingPriority returns the sampling priority set by the client on the trace root, if any.
+func GetSamplingPriority(root *model.Span) (int, bool) {
+	priority, ok := root.Metrics[model.SamplingPriorityKey]
+	return int(priority), ok
+}
+
 // SetTraceAppliedSampleRate sets the currently applied sample rate in the trace data to allow chained up sampling.
 func SetTraceAppliedSampleRate(root *model.Span, sampleRate float64) {
 	if root.Metrics == nil {
@@ -141,6 +141,88 @@ func TestSamplerChainedSampling(t *testing.T) {
 	assert.Equal(0.4, GetTraceAppliedSampleRate(rootAgain))
 }
 
+func TestSamplerPriority(t *testing.T) {
+	assert := assert.New(t)
+	s := getTestSampler()
+
+	// a very low maxTPS, which would make us drop most traces without a priority
+	s.maxTPS = 0.001
+	for i := 0; i < 1000; i++ {
+		trace, root := getTestTrace()
+		s.Sample(trace, root, defaultEnv)
+	}
+
+	for _, priority := range []float64{-1, 0, 1, 2} {
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			trace, root := getTestTrace()
+			root.Metrics = map[string]float64{model.SamplingPriorityKey: priority}
+			if s.Sample(trace, root, defaultEnv) {
+				kept++
+			}
+			// the applied sample rate is left untouched
+			assert.Equal(1.0, GetTraceAppliedSampleRate(root))
+		}
+
+		if priority > 0 {
+			assert.Equal(1000, kept, "all traces with priority %d should be kept", int(priority))
+		} else {
+			assert.Equal(0, kept, "no trace with priority %d should be kept", int(priority))
+		}
+	}
+}
+
+func TestGetSamplingPriority(t *testing.T) {
+	assert := assert.New(t)
+	_, root := getTestTrace()
+
+	_, ok := GetSamplingPriority(root)
+	assert.False(ok)
+
+	root.Metrics = map[string]float64{model.SamplingPriorityKey: 2}
+	priority, ok := GetSamplingPriority(root)
+	assert.True(ok)
+	assert.Equal(2, priority)
+}
+
+func TestSamplerServiceSampleRates(t *testing.T) {
+	assert := assert.New(t)
+	s := getTestSampler()
+
+	// Feed the sampler with many traces of the same service
+	for i := 0; i < int(1e5); i++ {
+		trace, root := getTestTrace()
+		s.Sample(trace, root, defaultEnv)
+	}
+	// and only a few of another one
+	trace, root := getTestTrace()
+	root.Service = "bunk"
+	s.Sample(trace, root, defaultEnv)
+
+	rates := s.GetServiceSampleRates()
+	assert.Len(rates, 2)
+
+	busy := rates[ServiceSignature{Name: "mcnulty", Env: defaultEnv}]
+	assert.True(busy > 0 && busy < 1, "busy service should be sampled, got %f", busy)
+	assert.Equal(1.0, rates[ServiceSignature{Name: "bunk", Env: defaultEnv}])
+
+	// the maxTPS limit applies on top of the rates of the signatures
+	s.maxTPS = s.Backend.GetUpperSampledScore() / 4
+	maxTPSrate := s.GetMaxTPSSampleRate()
+	assert.InEpsilon(0.25, maxTPSrate, 0.01)
+	rates = s.GetServiceSampleRates()
+	assert.Equal(busy*maxTPSrate, rates[ServiceSignature{Name: "mcnulty", Env: defaultEnv}])
+	assert.Equal(maxTPSrate, rates[ServiceSignature{Name: "bunk", Env: defaultEnv}])
+
+	// and so does the extra sample rate, as traces come
+	s.maxTPS = 0
+	s.extraRate = 0.5
+	trace, root = getTestTrace()
+	root.Service = "bunk"
+	s.Sample(trace, root, defaultEnv)
+	assert.Equal(0.75, s.GetServiceSampleRates()[ServiceSignature{Name: "bunk", Env: defaultEnv}])
+}
+
 func BenchmarkSampler(b *testing.B) {
 	// Benchmark the resource consumption of many traces sampling
 
@@ -21,6 +21,14 @@ func SampleByRate(traceID uint64, sampleRate float64) bool {
 	return true
 }
 
+// SampleTraceByRate is SampleByRate for trace IDs which may be 128-bit, given
+// as their upper and lower 64 bits. The upper bits are mixed in the hash, so
+// that traces sharing their lower bits are sampled independently, while
+// 64-bit IDs are sampled exactly like with SampleByRate.
+func SampleTraceByRate(traceIDHigh, traceID uint64, sampleRate float64) bool {
+	return SampleByRate(traceID^(traceIDHigh*samplerHasher), sampleRate)
+}
+
 // GetSignatureSampleRate gives the sample rate to apply to any signature
 // For now, only based on count score
 func (s *Sampler) GetSignatureSampleRate(signature Signature) float64 {
@@ -41,3 +49,18 @@ func (s *Sampler) GetCountScore(signature Signature) float64 {
 
 	return s.signatureScoreFactor / math.Pow(s.signatureScoreSlope, math.Log10(score))
 }
+
+// GetServiceSampleRates gives the sample rate currently applied to the traces of
+// each recently seen service, so that clients can sample at the source. It is
+// derived from the rates of the signatures of the service, weighted by their
+// recent throughput, and from the current maxTPS limit.
+func (s *Sampler) GetServiceSampleRates() map[ServiceSignature]float64 {
+	rates := s.Backend.GetServiceSampleRates()
+	maxTPSrate := s.GetMaxTPSSampleRate()
+
+	for signature := range rates {
+		rates[signature] *= maxTPSrate
+	}
+
+	return rates
+}
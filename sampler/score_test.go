@@ -35,3 +35,22 @@ func TestSampleRateManyTraces(t *testing.T) {
 		assert.InEpsilon(float64(sampled), float64(times)*rate, 0.01)
 	}
 }
+
+func TestSampleTraceByRate(t *testing.T) {
+	assert := assert.New(t)
+
+	// 64-bit IDs are sampled like before
+	for i := 0; i < 1000; i++ {
+		id := randomTraceID()
+		assert.Equal(SampleByRate(id, 0.5), SampleTraceByRate(0, id, 0.5))
+	}
+
+	// traces sharing their lower bits are sampled independently
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if SampleTraceByRate(randomTraceID(), 42, 0.5) {
+			sampled++
+		}
+	}
+	assert.InEpsilon(500, sampled, 0.2)
+}
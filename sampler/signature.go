@@ -44,6 +44,18 @@ func ComputeSignature(trace model.Trace) Signature {
 	return ComputeSignatureWithRootAndEnv(trace, root, env)
 }
 
+// ServiceSignature identifies the traces of a given service in a given env.
+// It is the key of the sample rates the agent advertises to the clients.
+type ServiceSignature struct {
+	Name string
+	Env  string
+}
+
+// String formats a ServiceSignature the way clients expect it, e.g. "service:mcnulty,env:prod"
+func (s ServiceSignature) String() string {
+	return "service:" + s.Name + ",env:" + s.Env
+}
+
 func computeSpanHash(span model.Span, env string) spanHash {
 	h := fnv.New32a()
 	h.Write([]byte(env))